@@ -0,0 +1,43 @@
+package ytcompare
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+//Backoff exponential backoff with jitter
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+//Duration waiting time before the attempt-th retry (starting from 0), a random jitter of up to half of the interval is applied,
+//retry immediately if base interval is not greater than 0
+func (backoff *Backoff) Duration(attempt int) time.Duration {
+	if backoff.Base <= 0 {
+		return 0
+	}
+	interval := backoff.Max
+	if attempt < 32 {
+		if d := backoff.Base << uint(attempt); d > 0 && d < backoff.Max {
+			interval = d
+		}
+	}
+	if interval <= 1 {
+		return interval
+	}
+	return interval/2 + time.Duration(rand.Int63n(int64(interval/2)))
+}
+
+//sleep wait for duration d, returns false if ctx is done before d elapses
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
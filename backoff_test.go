@@ -0,0 +1,28 @@
+package ytcompare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDuration(t *testing.T) {
+	backoff := &Backoff{Base: time.Second, Max: 8 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		d := backoff.Duration(attempt)
+		if d < max/2 || d > max {
+			t.Fatalf("attempt %d: expect duration in [%s, %s] but got %s", attempt, max/2, max, d)
+		}
+	}
+	if d := backoff.Duration(100); d < 4*time.Second || d > 8*time.Second {
+		t.Fatalf("expect duration capped by max interval but got %s", d)
+	}
+}
+
+func TestBackoffZeroBase(t *testing.T) {
+	backoff := &Backoff{Base: 0, Max: time.Minute}
+	for attempt := 0; attempt < 40; attempt++ {
+		if d := backoff.Duration(attempt); d != 0 {
+			t.Fatalf("attempt %d: expect retrying immediately but got %s", attempt, d)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package ytcompare
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+//BoltStateStore state store of embedded bbolt database, each table is saved as a bucket with JSON encoded values,
+//cursors and checkpoint of a window are committed in one transaction so no journal is needed
+type BoltStateStore struct {
+	db            *bolt.DB
+	keyCollection string
+}
+
+var _ StateStore = (*BoltStateStore)(nil)
+
+//NewBoltStateStore create a new BoltStateStore instance, database file is created if not exists
+func NewBoltStateStore(path, keyCollection string) (*BoltStateStore, error) {
+	entry := log.WithFields(log.Fields{Function: "NewBoltStateStore"})
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		entry.WithError(err).Errorf("opening bolt database failed: %s", path)
+		return nil, err
+	}
+	buckets := []string{CheckPointTab, CursorTab, ConflictTab}
+	if keyCollection != "" {
+		buckets = append(buckets, keyCollection)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		entry.WithError(err).Errorf("creating buckets of bolt database failed: %s", path)
+		db.Close()
+		return nil, err
+	}
+	return &BoltStateStore{db: db, keyCollection: keyCollection}, nil
+}
+
+//int32Key encode ID as key of bucket
+func int32Key(id int32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(id))
+	return key
+}
+
+//conflictKey encode start of window and shard ID as key of conflict bucket
+func conflictKey(start, shardID int64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[0:8], uint64(start))
+	binary.BigEndian.PutUint64(key[8:16], uint64(shardID))
+	return key
+}
+
+//get decode value of key in bucket into v, ErrStateNotFound is returned if key not exists
+func (store *BoltStateStore) get(bucket string, key []byte, v interface{}) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrStateNotFound
+		}
+		value := b.Get(key)
+		if value == nil {
+			return ErrStateNotFound
+		}
+		return json.Unmarshal(value, v)
+	})
+}
+
+//put encode v and save as value of key in bucket
+func put(tx *bolt.Tx, bucket string, key []byte, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucket)).Put(key, value)
+}
+
+//GetCheckPoint get checkpoint of the last committed window
+func (store *BoltStateStore) GetCheckPoint(ctx context.Context) (*CheckPoint, error) {
+	checkPoint := new(CheckPoint)
+	if err := store.get(CheckPointTab, int32Key(1), checkPoint); err != nil {
+		return nil, err
+	}
+	return checkPoint, nil
+}
+
+//GetCursor get cursor of miner
+func (store *BoltStateStore) GetCursor(ctx context.Context, nodeID int32) (*Cursor, error) {
+	cursor := new(Cursor)
+	if err := store.get(CursorTab, int32Key(nodeID), cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+//Commit write cursors and checkpoint of journal in one transaction
+func (store *BoltStateStore) Commit(ctx context.Context, journal *Journal) error {
+	entry := log.WithFields(log.Fields{Function: "Commit"})
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		for _, cursor := range journal.Cursors {
+			if err := put(tx, CursorTab, int32Key(cursor.ID), cursor); err != nil {
+				return err
+			}
+		}
+		return put(tx, CheckPointTab, int32Key(journal.CheckPoint.ID), journal.CheckPoint)
+	})
+	if err != nil {
+		entry.WithError(err).Errorf("committing window %d", journal.CheckPoint.Start)
+		return err
+	}
+	entry.Debugf("window %d committed: %+v", journal.CheckPoint.Start, journal.CheckPoint)
+	return nil
+}
+
+//Recover nothing to do since commits are atomic
+func (store *BoltStateStore) Recover(ctx context.Context) error {
+	return nil
+}
+
+//SaveConflicts save conflicts of one window
+func (store *BoltStateStore) SaveConflicts(ctx context.Context, conflicts []*Conflict) error {
+	entry := log.WithFields(log.Fields{Function: "SaveConflicts"})
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ConflictTab))
+		for _, conflict := range conflicts {
+			key := conflictKey(conflict.Start, conflict.ShardID)
+			if value := b.Get(key); value != nil {
+				old := new(Conflict)
+				if err := json.Unmarshal(value, old); err != nil {
+					return err
+				}
+				conflict = mergeConflict(old, conflict)
+			}
+			if err := put(tx, ConflictTab, key, conflict); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		entry.WithError(err).Errorf("saving %d conflict records", len(conflicts))
+		return err
+	}
+	return nil
+}
+
+//mergeConflict merge assignments of conflict into old one of the same shard and window
+func mergeConflict(old, conflict *Conflict) *Conflict {
+	merged := &Conflict{ShardID: conflict.ShardID, Start: conflict.Start, Range: conflict.Range, Assignments: old.Assignments, Timestamp: conflict.Timestamp}
+	for _, a := range conflict.Assignments {
+		exists := false
+		for _, b := range old.Assignments {
+			if *a == *b {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			merged.Assignments = append(merged.Assignments, a)
+		}
+	}
+	return merged
+}
+
+//GetMinerKey get base64 encoded public key of miner
+func (store *BoltStateStore) GetMinerKey(ctx context.Context, nodeID int32) (string, error) {
+	minerKey := new(MinerKey)
+	if err := store.get(store.keyCollection, int32Key(nodeID), minerKey); err != nil {
+		return "", err
+	}
+	return minerKey.PublicKey, nil
+}
+
+//PutMinerKey set base64 encoded public key of miner
+func (store *BoltStateStore) PutMinerKey(ctx context.Context, nodeID int32, publicKey string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(store.keyCollection)); err != nil {
+			return err
+		}
+		return put(tx, store.keyCollection, int32Key(nodeID), &MinerKey{ID: nodeID, PublicKey: publicKey})
+	})
+}
+
+//Close close the database file
+func (store *BoltStateStore) Close(ctx context.Context) error {
+	return store.db.Close()
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+var genMinerKey bool
+
+// genkeyCmd generates a new Ed25519 key pair for signing compare files
+var genkeyCmd = &cobra.Command{
+	Use:   "genkey",
+	Short: "generate Ed25519 key pair for signing compare files or X25519 key pair of miner",
+	Long: `genkey generates a new Ed25519 key pair, the private key is used as sign-key of compare service and the public key should be distributed to miners for verifying compare files.
+With --miner flag, genkey generates a new X25519 key pair of miner, the public key should be saved in key collection of compare service and the private key is used by miner for decrypting compare files.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if genMinerKey {
+			publicKey, privateKey, err := cmpfile.GenerateKey(rand.Reader)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("private key: %s\n", base64.StdEncoding.EncodeToString(privateKey))
+			fmt.Printf("public key: %s\n", base64.StdEncoding.EncodeToString(publicKey))
+			return
+		}
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("private key: %s\n", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+		fmt.Printf("public key: %s\n", base64.StdEncoding.EncodeToString(publicKey))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(genkeyCmd)
+	genkeyCmd.Flags().BoolVar(&genMinerKey, "miner", false, "generate X25519 key pair of miner for encrypting compare files")
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -35,7 +37,21 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			panic(fmt.Sprintf("fatal error when starting compare service: %s\n", err))
 		}
-		compare.Start(context.Background())
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Infof("received signal %s, shutting down", sig)
+			cancel()
+			sig = <-signals
+			log.Warnf("received signal %s again, exiting immediately", sig)
+			os.Exit(1)
+		}()
+		compare.Start(ctx)
+		if err := compare.Close(context.Background()); err != nil {
+			log.WithError(err).Error("closing compare service")
+		}
 		// config := new(ytsync.Config)
 		// if err := viper.Unmarshal(config); err != nil {
 		// 	panic(fmt.Sprintf("unable to decode into config struct, %v\n", err))
@@ -155,16 +171,62 @@ var (
 	DefaultMongoDBURL string = "mongodb://127.0.0.1:27017/?connect=direct"
 	//DefaultDBName default value of DBName
 	DefaultDBName string = "compare"
+	//DefaultStateStore default value of StateStore
+	DefaultStateStore string = "mongo"
+	//DefaultBoltPath default value of BoltPath
+	DefaultBoltPath string = "./compare.db"
 	//DefaultAllSyncURLs default value of AllSyncURLs
 	DefaultAllSyncURLs []string = []string{}
 	//DefaultStartTime default value of StartTime
 	DefaultStartTime int = 0
 	//DefaultTimeRange default value of TimeRange
 	DefaultTimeRange int = 600
+	//DefaultMinTimeRange default value of MinTimeRange
+	DefaultMinTimeRange int = 0
+	//DefaultMaxTimeRange default value of MaxTimeRange
+	DefaultMaxTimeRange int = 0
+	//DefaultTargetShards default value of TargetShards
+	DefaultTargetShards int64 = 0
 	//DefaultWaitTime default value of WaitTime
 	DefaultWaitTime int = 60
 	//DefaultSkipTime default value of SkipTime
 	DefaultSkipTime int = 300
+	//DefaultRetryTimes default value of RetryTimes
+	DefaultRetryTimes int = 5
+	//DefaultRetryInterval default value of RetryInterval
+	DefaultRetryInterval int = 1
+	//DefaultRetryMaxInterval default value of RetryMaxInterval
+	DefaultRetryMaxInterval int = 60
+	//DefaultPageSize default value of PageSize
+	DefaultPageSize int = 10000
+	//DefaultRequestTimeout default value of RequestTimeout
+	DefaultRequestTimeout int = 60
+	//DefaultRecordMode default value of RecordMode
+	DefaultRecordMode string = "vhf"
+	//DefaultSignKey default value of SignKey
+	DefaultSignKey string = ""
+	//DefaultChainMode default value of ChainMode
+	DefaultChainMode string = "tag"
+	//DefaultManifestMaxFiles default value of ManifestMaxFiles
+	DefaultManifestMaxFiles int = 1008
+	//DefaultShutdownTimeout default value of ShutdownTimeout
+	DefaultShutdownTimeout int = 60
+	//DefaultCatchUpWindows default value of CatchUpWindows
+	DefaultCatchUpWindows int = 1
+	//DefaultUploadConcurrency default value of UploadConcurrency
+	DefaultUploadConcurrency int = 32
+	//DefaultSpillMemoryLimit default value of SpillMemoryLimit
+	DefaultSpillMemoryLimit int = 0
+	//DefaultSpillDir default value of SpillDir
+	DefaultSpillDir string = ""
+	//DefaultEncryptionEnabled default value of EncryptionEnabled
+	DefaultEncryptionEnabled bool = false
+	//DefaultEncryptionKeyCollection default value of EncryptionKeyCollection
+	DefaultEncryptionKeyCollection string = "minerkey"
+	//DefaultEncryptionAllowPlaintext default value of EncryptionAllowPlaintext
+	DefaultEncryptionAllowPlaintext bool = false
+	//DefaultStorageType default value of StorageType
+	DefaultStorageType string = "cos"
 
 	//DefaultCOSSchema default value of COSSchema
 	DefaultCOSSchema string = "https"
@@ -177,6 +239,24 @@ var (
 	//DefaultCOSSecretKey default value of COSSecretKey
 	DefaultCOSSecretKey string = ""
 
+	//DefaultS3Endpoint default value of S3Endpoint
+	DefaultS3Endpoint string = "127.0.0.1:9000"
+	//DefaultS3Region default value of S3Region
+	DefaultS3Region string = ""
+	//DefaultS3BucketName default value of S3BucketName
+	DefaultS3BucketName string = "compare"
+	//DefaultS3AccessKey default value of S3AccessKey
+	DefaultS3AccessKey string = ""
+	//DefaultS3SecretKey default value of S3SecretKey
+	DefaultS3SecretKey string = ""
+	//DefaultS3UseSSL default value of S3UseSSL
+	DefaultS3UseSSL bool = false
+
+	//DefaultFSRootDir default value of FSRootDir
+	DefaultFSRootDir string = "./compare-data"
+	//DefaultFSBindAddr default value of FSBindAddr
+	DefaultFSBindAddr string = ""
+
 	//DefaultLoggerOutput default value of LoggerOutput
 	DefaultLoggerOutput string = "stdout"
 	//DefaultLoggerFilePath default value of LoggerFilePath
@@ -195,16 +275,62 @@ func initFlag() {
 	viper.BindPFlag(ytcompare.MongoDBURLField, rootCmd.PersistentFlags().Lookup(ytcompare.MongoDBURLField))
 	rootCmd.PersistentFlags().String(ytcompare.DBNameField, DefaultDBName, "name of database")
 	viper.BindPFlag(ytcompare.DBNameField, rootCmd.PersistentFlags().Lookup(ytcompare.DBNameField))
+	rootCmd.PersistentFlags().String(ytcompare.StateStoreField, DefaultStateStore, "where service state is saved: mongo or bolt")
+	viper.BindPFlag(ytcompare.StateStoreField, rootCmd.PersistentFlags().Lookup(ytcompare.StateStoreField))
+	rootCmd.PersistentFlags().String(ytcompare.BoltPathField, DefaultBoltPath, "path of database file when state-store is bolt")
+	viper.BindPFlag(ytcompare.BoltPathField, rootCmd.PersistentFlags().Lookup(ytcompare.BoltPathField))
 	rootCmd.PersistentFlags().StringSlice(ytcompare.AllSyncURLsField, DefaultAllSyncURLs, "all URLs of sync services, in the form of --all-sync-urls \"URL1,URL2,URL3\"")
 	viper.BindPFlag(ytcompare.AllSyncURLsField, rootCmd.PersistentFlags().Lookup(ytcompare.AllSyncURLsField))
 	rootCmd.PersistentFlags().Int(ytcompare.StartTimeField, DefaultStartTime, "get shards from this timestamp")
 	viper.BindPFlag(ytcompare.StartTimeField, rootCmd.PersistentFlags().Lookup(ytcompare.StartTimeField))
 	rootCmd.PersistentFlags().Int(ytcompare.TimeRangeField, DefaultTimeRange, "time range when fetching shards for comparing")
 	viper.BindPFlag(ytcompare.TimeRangeField, rootCmd.PersistentFlags().Lookup(ytcompare.TimeRangeField))
+	rootCmd.PersistentFlags().Int(ytcompare.MinTimeRangeField, DefaultMinTimeRange, "min time range of window when adapting range to shard volume, use time-range if set to 0")
+	viper.BindPFlag(ytcompare.MinTimeRangeField, rootCmd.PersistentFlags().Lookup(ytcompare.MinTimeRangeField))
+	rootCmd.PersistentFlags().Int(ytcompare.MaxTimeRangeField, DefaultMaxTimeRange, "max time range of window when adapting range to shard volume, use time-range if set to 0")
+	viper.BindPFlag(ytcompare.MaxTimeRangeField, rootCmd.PersistentFlags().Lookup(ytcompare.MaxTimeRangeField))
+	rootCmd.PersistentFlags().Int64(ytcompare.TargetShardsField, DefaultTargetShards, "expected count of shards in each window for adapting time range of window, time range is fixed if set to 0")
+	viper.BindPFlag(ytcompare.TargetShardsField, rootCmd.PersistentFlags().Lookup(ytcompare.TargetShardsField))
 	rootCmd.PersistentFlags().Int(ytcompare.WaitTimeField, DefaultWaitTime, "wait time when no new shards can be fetched")
 	viper.BindPFlag(ytcompare.WaitTimeField, rootCmd.PersistentFlags().Lookup(ytcompare.WaitTimeField))
 	rootCmd.PersistentFlags().Int(ytcompare.SkipTimeField, DefaultSkipTime, "ensure not to fetching shards till the end")
 	viper.BindPFlag(ytcompare.SkipTimeField, rootCmd.PersistentFlags().Lookup(ytcompare.SkipTimeField))
+	rootCmd.PersistentFlags().Int(ytcompare.RetryTimesField, DefaultRetryTimes, "max retry times when fetching shards from one SN failed")
+	viper.BindPFlag(ytcompare.RetryTimesField, rootCmd.PersistentFlags().Lookup(ytcompare.RetryTimesField))
+	rootCmd.PersistentFlags().Int(ytcompare.RetryIntervalField, DefaultRetryInterval, "initial interval(second) of exponential backoff when retrying, retry immediately if set to 0")
+	viper.BindPFlag(ytcompare.RetryIntervalField, rootCmd.PersistentFlags().Lookup(ytcompare.RetryIntervalField))
+	rootCmd.PersistentFlags().Int(ytcompare.RetryMaxIntervalField, DefaultRetryMaxInterval, "max interval(second) of exponential backoff when retrying")
+	viper.BindPFlag(ytcompare.RetryMaxIntervalField, rootCmd.PersistentFlags().Lookup(ytcompare.RetryMaxIntervalField))
+	rootCmd.PersistentFlags().Int(ytcompare.PageSizeField, DefaultPageSize, "max count of shards fetched from sync service in one request, paging is disabled if set to 0")
+	viper.BindPFlag(ytcompare.PageSizeField, rootCmd.PersistentFlags().Lookup(ytcompare.PageSizeField))
+	rootCmd.PersistentFlags().Int(ytcompare.RequestTimeoutField, DefaultRequestTimeout, "timeout in seconds of each request to sync service including reading response, no timeout if set to 0")
+	viper.BindPFlag(ytcompare.RequestTimeoutField, rootCmd.PersistentFlags().Lookup(ytcompare.RequestTimeoutField))
+	rootCmd.PersistentFlags().String(ytcompare.RecordModeField, DefaultRecordMode, "content of records in compare file(vhf for VHF only, full for shard ID, block ID and VHF)")
+	viper.BindPFlag(ytcompare.RecordModeField, rootCmd.PersistentFlags().Lookup(ytcompare.RecordModeField))
+	rootCmd.PersistentFlags().String(ytcompare.SignKeyField, DefaultSignKey, "base64 encoded Ed25519 private key for signing compare files, not signing if empty")
+	viper.BindPFlag(ytcompare.SignKeyField, rootCmd.PersistentFlags().Lookup(ytcompare.SignKeyField))
+	rootCmd.PersistentFlags().String(ytcompare.ChainModeField, DefaultChainMode, "how compare files are chained(tag, manifest or both)")
+	viper.BindPFlag(ytcompare.ChainModeField, rootCmd.PersistentFlags().Lookup(ytcompare.ChainModeField))
+	rootCmd.PersistentFlags().Int(ytcompare.ManifestMaxFilesField, DefaultManifestMaxFiles, "max count of latest compare files kept in manifest of each miner, keep all files if set to 0")
+	viper.BindPFlag(ytcompare.ManifestMaxFilesField, rootCmd.PersistentFlags().Lookup(ytcompare.ManifestMaxFilesField))
+	rootCmd.PersistentFlags().Int(ytcompare.ShutdownTimeoutField, DefaultShutdownTimeout, "max seconds waiting for uploads in flight to finish after receiving SIGINT or SIGTERM")
+	viper.BindPFlag(ytcompare.ShutdownTimeoutField, rootCmd.PersistentFlags().Lookup(ytcompare.ShutdownTimeoutField))
+	rootCmd.PersistentFlags().Int(ytcompare.CatchUpWindowsField, DefaultCatchUpWindows, "max count of windows fetched concurrently while previous window is being uploaded, windows are still uploaded and committed in order")
+	viper.BindPFlag(ytcompare.CatchUpWindowsField, rootCmd.PersistentFlags().Lookup(ytcompare.CatchUpWindowsField))
+	rootCmd.PersistentFlags().Int(ytcompare.UploadConcurrencyField, DefaultUploadConcurrency, "max count of compare files generated and uploaded concurrently, no limit if set to 0")
+	viper.BindPFlag(ytcompare.UploadConcurrencyField, rootCmd.PersistentFlags().Lookup(ytcompare.UploadConcurrencyField))
+	rootCmd.PersistentFlags().Int(ytcompare.SpillMemoryLimitField, DefaultSpillMemoryLimit, "max memory(MB) used for caching VHFs and indexing shards for conflicts before spilling VHFs to disk, never spill if set to 0")
+	viper.BindPFlag(ytcompare.SpillMemoryLimitField, rootCmd.PersistentFlags().Lookup(ytcompare.SpillMemoryLimitField))
+	rootCmd.PersistentFlags().String(ytcompare.SpillDirField, DefaultSpillDir, "directory of spill files, use system temporary directory if empty")
+	viper.BindPFlag(ytcompare.SpillDirField, rootCmd.PersistentFlags().Lookup(ytcompare.SpillDirField))
+	rootCmd.PersistentFlags().Bool(ytcompare.EncryptionEnabledField, DefaultEncryptionEnabled, "whether encrypting compare files to public keys of miners")
+	viper.BindPFlag(ytcompare.EncryptionEnabledField, rootCmd.PersistentFlags().Lookup(ytcompare.EncryptionEnabledField))
+	rootCmd.PersistentFlags().String(ytcompare.EncryptionKeyCollectionField, DefaultEncryptionKeyCollection, "collection of public keys of miners")
+	viper.BindPFlag(ytcompare.EncryptionKeyCollectionField, rootCmd.PersistentFlags().Lookup(ytcompare.EncryptionKeyCollectionField))
+	rootCmd.PersistentFlags().Bool(ytcompare.EncryptionAllowPlaintextField, DefaultEncryptionAllowPlaintext, "whether uploading compare files without encryption for miners having no public key")
+	viper.BindPFlag(ytcompare.EncryptionAllowPlaintextField, rootCmd.PersistentFlags().Lookup(ytcompare.EncryptionAllowPlaintextField))
+	rootCmd.PersistentFlags().String(ytcompare.StorageTypeField, DefaultStorageType, "type of storage for uploading compare data(cos, s3 or fs)")
+	viper.BindPFlag(ytcompare.StorageTypeField, rootCmd.PersistentFlags().Lookup(ytcompare.StorageTypeField))
 	//COS config
 	rootCmd.PersistentFlags().String(ytcompare.COSSchemaField, DefaultCOSSchema, "schema of COS connection")
 	viper.BindPFlag(ytcompare.COSSchemaField, rootCmd.PersistentFlags().Lookup(ytcompare.COSSchemaField))
@@ -216,6 +342,24 @@ func initFlag() {
 	viper.BindPFlag(ytcompare.COSSecretIDField, rootCmd.PersistentFlags().Lookup(ytcompare.COSSecretIDField))
 	rootCmd.PersistentFlags().String(ytcompare.COSSecretKeyField, DefaultCOSSecretKey, "secret key of COS")
 	viper.BindPFlag(ytcompare.COSSecretKeyField, rootCmd.PersistentFlags().Lookup(ytcompare.COSSecretKeyField))
+	//S3 config
+	rootCmd.PersistentFlags().String(ytcompare.S3EndpointField, DefaultS3Endpoint, "endpoint of S3 service")
+	viper.BindPFlag(ytcompare.S3EndpointField, rootCmd.PersistentFlags().Lookup(ytcompare.S3EndpointField))
+	rootCmd.PersistentFlags().String(ytcompare.S3RegionField, DefaultS3Region, "region of S3 service")
+	viper.BindPFlag(ytcompare.S3RegionField, rootCmd.PersistentFlags().Lookup(ytcompare.S3RegionField))
+	rootCmd.PersistentFlags().String(ytcompare.S3BucketNameField, DefaultS3BucketName, "bucket name of S3")
+	viper.BindPFlag(ytcompare.S3BucketNameField, rootCmd.PersistentFlags().Lookup(ytcompare.S3BucketNameField))
+	rootCmd.PersistentFlags().String(ytcompare.S3AccessKeyField, DefaultS3AccessKey, "access key of S3")
+	viper.BindPFlag(ytcompare.S3AccessKeyField, rootCmd.PersistentFlags().Lookup(ytcompare.S3AccessKeyField))
+	rootCmd.PersistentFlags().String(ytcompare.S3SecretKeyField, DefaultS3SecretKey, "secret key of S3")
+	viper.BindPFlag(ytcompare.S3SecretKeyField, rootCmd.PersistentFlags().Lookup(ytcompare.S3SecretKeyField))
+	rootCmd.PersistentFlags().Bool(ytcompare.S3UseSSLField, DefaultS3UseSSL, "whether connecting S3 service by HTTPS")
+	viper.BindPFlag(ytcompare.S3UseSSLField, rootCmd.PersistentFlags().Lookup(ytcompare.S3UseSSLField))
+	//filesystem storage config
+	rootCmd.PersistentFlags().String(ytcompare.FSRootDirField, DefaultFSRootDir, "root directory of filesystem storage")
+	viper.BindPFlag(ytcompare.FSRootDirField, rootCmd.PersistentFlags().Lookup(ytcompare.FSRootDirField))
+	rootCmd.PersistentFlags().String(ytcompare.FSBindAddrField, DefaultFSBindAddr, "binding address of HTTP server for downloading compare data in filesystem storage, disabled if empty")
+	viper.BindPFlag(ytcompare.FSBindAddrField, rootCmd.PersistentFlags().Lookup(ytcompare.FSBindAddrField))
 	//logger config
 	rootCmd.PersistentFlags().String(ytcompare.LoggerOutputField, DefaultLoggerOutput, "Output type of logger(stdout or file)")
 	viper.BindPFlag(ytcompare.LoggerOutputField, rootCmd.PersistentFlags().Lookup(ytcompare.LoggerOutputField))
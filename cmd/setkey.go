@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	ytcompare "github.com/yottachain/yotta-compare"
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+// setkeyCmd saves X25519 public key of miner in state store
+var setkeyCmd = &cobra.Command{
+	Use:   "setkey <miner ID> <public key>",
+	Short: "save X25519 public key of miner for encrypting compare files",
+	Long:  `setkey saves base64 encoded X25519 public key of miner in key collection of the configured state store, compare files of the miner are encrypted to this key when encryption is enabled.`,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		minerID, err := strconv.ParseInt(args[0], 10, 32)
+		if err != nil {
+			fmt.Printf("invalid miner ID: %s\n", args[0])
+			os.Exit(1)
+		}
+		if _, err := cmpfile.ParseKey(args[1]); err != nil {
+			fmt.Printf("invalid public key: %s\n", err)
+			os.Exit(1)
+		}
+		config := new(ytcompare.Config)
+		if err := viper.Unmarshal(config); err != nil {
+			fmt.Printf("unable to decode into config struct, %v\n", err)
+			os.Exit(1)
+		}
+		ctx := context.Background()
+		state, err := ytcompare.NewStateStore(ctx, config)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer state.Close(ctx)
+		if err := state.PutMinerKey(ctx, int32(minerID), args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("public key of miner %d saved\n", minerID)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setkeyCmd)
+}
@@ -0,0 +1,128 @@
+//Package cmpfile implements the format of compare files, it is used by compare service for generating compare files
+//and can be used by miners for parsing them.
+//
+//A compare file is gzip compressed, the uncompressed content consists of a header, records and a trailer,
+//all integers are in big endian:
+//
+//	magic     [4]byte  "YTCF"
+//	version   uint16   version of format, currently 1
+//	flags     uint16   flags of file, combination of FlagShardInfo, FlagSortedByID and FlagSortedByVHF
+//	minerID   int32    ID of miner
+//	start     int64    start timestamp of window
+//	range     int64    time range of window in seconds
+//	count     uint64   count of records
+//	vhfLen    uint16   length of VHF in each record, must not be 0 if count is not 0
+//	records   count records, each record is a VHF of vhfLen bytes,
+//	          or shard ID(int64) + block ID(int64) + VHF of vhfLen bytes if FlagShardInfo is set,
+//	          records are sorted by shard ID if FlagSortedByID is set, or by VHF if FlagSortedByVHF is set
+//	checksum  [32]byte SHA-256 of all bytes before checksum
+package cmpfile
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	//Version current version of format
+	Version uint16 = 1
+	//HeaderSize size of header in bytes
+	HeaderSize = 4 + 2 + 2 + 4 + 8 + 8 + 8 + 2
+	//ChecksumSize size of checksum in bytes
+	ChecksumSize = 32
+)
+
+const (
+	//FlagShardInfo records contain shard ID and block ID besides VHF
+	FlagShardInfo uint16 = 1 << iota
+	//FlagSortedByID records are sorted by shard ID in ascending order, records with same shard ID are sorted by VHF, only valid with FlagShardInfo
+	FlagSortedByID
+	//FlagSortedByVHF records are sorted by VHF in ascending order, records with same VHF are sorted by shard ID
+	FlagSortedByVHF
+)
+
+//knownFlags all flags supported by current version
+const knownFlags = FlagShardInfo | FlagSortedByID | FlagSortedByVHF
+
+//Magic magic number of compare file
+var Magic = [4]byte{'Y', 'T', 'C', 'F'}
+
+var (
+	//ErrInvalidMagic magic number mismatch
+	ErrInvalidMagic = errors.New("cmpfile: invalid magic number")
+	//ErrUnsupportedVersion version of format not supported
+	ErrUnsupportedVersion = errors.New("cmpfile: unsupported version")
+	//ErrUnsupportedFlags flags of file not supported
+	ErrUnsupportedFlags = errors.New("cmpfile: unsupported flags")
+	//ErrChecksumMismatch checksum of file mismatch
+	ErrChecksumMismatch = errors.New("cmpfile: checksum mismatch")
+	//ErrRecordCount count of records mismatch with header
+	ErrRecordCount = errors.New("cmpfile: record count mismatch")
+	//ErrRecordLength length of record mismatch with header
+	ErrRecordLength = errors.New("cmpfile: record length mismatch")
+	//ErrRecordOrder records are not in the order specified by flags
+	ErrRecordOrder = errors.New("cmpfile: records out of order")
+)
+
+//Header header of compare file
+type Header struct {
+	Version uint16
+	Flags   uint16
+	MinerID int32
+	Start   int64
+	Range   int64
+	Count   uint64
+	VHFLen  uint16
+}
+
+//validate check whether flags of header are supported
+func (header *Header) validate() error {
+	if header.Flags&^knownFlags != 0 {
+		return ErrUnsupportedFlags
+	}
+	if header.Flags&FlagSortedByID != 0 && header.Flags&FlagSortedByVHF != 0 {
+		return ErrUnsupportedFlags
+	}
+	if header.Flags&FlagSortedByID != 0 && header.Flags&FlagShardInfo == 0 {
+		return ErrUnsupportedFlags
+	}
+	return nil
+}
+
+//Sorted whether records are sorted
+func (header *Header) Sorted() bool {
+	return header.Flags&(FlagSortedByID|FlagSortedByVHF) != 0
+}
+
+//Less whether record a must precede record b in the order specified by flags of header, always false if records are not sorted
+func (header *Header) Less(a, b *Record) bool {
+	switch {
+	case header.Flags&FlagSortedByID != 0:
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return bytes.Compare(a.VHF, b.VHF) < 0
+	case header.Flags&FlagSortedByVHF != 0:
+		if c := bytes.Compare(a.VHF, b.VHF); c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	default:
+		return false
+	}
+}
+
+//recordSize size of one record in bytes
+func (header *Header) recordSize() int {
+	if header.Flags&FlagShardInfo != 0 {
+		return 16 + int(header.VHFLen)
+	}
+	return int(header.VHFLen)
+}
+
+//Record record of compare file
+type Record struct {
+	ID      int64
+	BlockID int64
+	VHF     []byte
+}
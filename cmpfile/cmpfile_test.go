@@ -0,0 +1,206 @@
+package cmpfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+//writeFile write records into a compare file with header
+func writeFile(t *testing.T, header *Header, records []*Record) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	header.Count = uint64(len(records))
+	writer, err := NewWriter(&buf, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//readFile read all records of compare file
+func readFile(data []byte) (Header, []Record, error) {
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer reader.Close()
+	var records []Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return reader.Header(), records, nil
+		}
+		if err != nil {
+			return reader.Header(), records, err
+		}
+		records = append(records, Record{ID: record.ID, BlockID: record.BlockID, VHF: append([]byte{}, record.VHF...)})
+	}
+}
+
+//rawFile build a compare file byte by byte without validation of writer, checksum is corrupted if corrupt is true
+func rawFile(header Header, records [][]byte, corrupt bool) []byte {
+	var content bytes.Buffer
+	buf := make([]byte, HeaderSize)
+	copy(buf[0:4], Magic[:])
+	binary.BigEndian.PutUint16(buf[4:6], header.Version)
+	binary.BigEndian.PutUint16(buf[6:8], header.Flags)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(header.MinerID))
+	binary.BigEndian.PutUint64(buf[12:20], uint64(header.Start))
+	binary.BigEndian.PutUint64(buf[20:28], uint64(header.Range))
+	binary.BigEndian.PutUint64(buf[28:36], header.Count)
+	binary.BigEndian.PutUint16(buf[36:38], header.VHFLen)
+	content.Write(buf)
+	for _, record := range records {
+		content.Write(record)
+	}
+	sum := sha256.Sum256(content.Bytes())
+	if corrupt {
+		sum[0] ^= 0xff
+	}
+	content.Write(sum[:])
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	gz.Write(content.Bytes())
+	gz.Close()
+	return out.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		flags   uint16
+		records []*Record
+	}{
+		{"vhf", FlagSortedByVHF, []*Record{{VHF: []byte{1, 2, 3}}, {VHF: []byte{1, 2, 4}}, {VHF: []byte{9, 0, 0}}}},
+		{"full", FlagShardInfo | FlagSortedByID, []*Record{{ID: 1, BlockID: 10, VHF: []byte{9, 9}}, {ID: 2, BlockID: 10, VHF: []byte{1, 1}}, {ID: 2, BlockID: 11, VHF: []byte{2, 2}}}},
+		{"unsorted", FlagShardInfo, []*Record{{ID: 3, BlockID: 1, VHF: []byte{5}}, {ID: 1, BlockID: 2, VHF: []byte{4}}}},
+		{"empty", FlagSortedByVHF, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := writeFile(t, &Header{Flags: c.flags, MinerID: 7, Start: 1600000000, Range: 600}, c.records)
+			header, records, err := readFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Version != Version || header.Flags != c.flags || header.MinerID != 7 || header.Start != 1600000000 || header.Range != 600 || header.Count != uint64(len(c.records)) {
+				t.Fatalf("header mismatch: %+v", header)
+			}
+			if len(records) != len(c.records) {
+				t.Fatalf("got %d records, want %d", len(records), len(c.records))
+			}
+			for i, record := range records {
+				want := *c.records[i]
+				if c.flags&FlagShardInfo == 0 {
+					want.ID, want.BlockID = 0, 0
+				}
+				if record.ID != want.ID || record.BlockID != want.BlockID || !bytes.Equal(record.VHF, want.VHF) {
+					t.Fatalf("record %d: got %+v, want %+v", i, record, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	if _, err := NewWriter(ioutil.Discard, &Header{Flags: FlagSortedByID}); err != ErrUnsupportedFlags {
+		t.Fatalf("sorted by ID without shard info: got %v", err)
+	}
+	if _, err := NewWriter(ioutil.Discard, &Header{Flags: FlagShardInfo | FlagSortedByID | FlagSortedByVHF}); err != ErrUnsupportedFlags {
+		t.Fatalf("both sort flags: got %v", err)
+	}
+	writer, _ := NewWriter(ioutil.Discard, &Header{Flags: FlagSortedByVHF, Count: 2})
+	if err := writer.WriteRecord(&Record{VHF: []byte{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteRecord(&Record{VHF: []byte{1}}); err != ErrRecordOrder {
+		t.Fatalf("out of order: got %v", err)
+	}
+	if err := writer.WriteRecord(&Record{VHF: []byte{3, 4}}); err != ErrRecordLength {
+		t.Fatalf("length mismatch: got %v", err)
+	}
+	writer, _ = NewWriter(ioutil.Discard, &Header{Flags: FlagSortedByVHF, Count: 1})
+	if err := writer.WriteRecord(&Record{}); err != ErrRecordLength {
+		t.Fatalf("empty VHF: got %v", err)
+	}
+	writer, _ = NewWriter(ioutil.Discard, &Header{Flags: FlagSortedByVHF, Count: 2})
+	writer.WriteRecord(&Record{VHF: []byte{1}})
+	if err := writer.Close(); err != ErrRecordCount {
+		t.Fatalf("missing records: got %v", err)
+	}
+}
+
+func TestReaderOrder(t *testing.T) {
+	header := Header{Version: Version, Flags: FlagSortedByVHF, Count: 2, VHFLen: 1}
+	_, records, err := readFile(rawFile(header, [][]byte{{2}, {1}}, false))
+	if err != ErrRecordOrder {
+		t.Fatalf("got %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records before order error", len(records))
+	}
+}
+
+func TestReaderChecksum(t *testing.T) {
+	header := Header{Version: Version, Flags: FlagSortedByVHF, Count: 2, VHFLen: 1}
+	if _, _, err := readFile(rawFile(header, [][]byte{{1}, {2}}, false)); err != nil {
+		t.Fatalf("valid file: %v", err)
+	}
+	if _, _, err := readFile(rawFile(header, [][]byte{{1}, {2}}, true)); err != ErrChecksumMismatch {
+		t.Fatalf("corrupted checksum: got %v", err)
+	}
+	data := writeFile(t, &Header{Flags: FlagSortedByVHF}, []*Record{{VHF: []byte{1, 2}}})
+	gz, _ := gzip.NewReader(bytes.NewReader(data))
+	content, _ := ioutil.ReadAll(gz)
+	content[HeaderSize] ^= 0xff
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	w.Write(content)
+	w.Close()
+	if _, _, err := readFile(out.Bytes()); err != ErrChecksumMismatch {
+		t.Fatalf("corrupted record: got %v", err)
+	}
+}
+
+func TestReaderHeader(t *testing.T) {
+	cases := []struct {
+		name   string
+		header Header
+		want   error
+	}{
+		{"zero length records", Header{Version: Version, Flags: FlagSortedByVHF, Count: 1 << 63, VHFLen: 0}, ErrRecordLength},
+		{"unknown version", Header{Version: Version + 1, Flags: FlagSortedByVHF, Count: 0, VHFLen: 1}, ErrUnsupportedVersion},
+		{"unknown flags", Header{Version: Version, Flags: 1 << 15, Count: 0, VHFLen: 1}, ErrUnsupportedFlags},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, _, err := readFile(rawFile(c.header, nil, false)); err != c.want {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+	header := Header{Version: Version, Flags: FlagSortedByVHF, Count: 3, VHFLen: 1}
+	if _, _, err := readFile(rawFile(header, [][]byte{{1}, {2}}, false)); err != ErrChecksumMismatch && err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated records: got %v", err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("this is not a compare file, but it is long enough"))
+	gz.Close()
+	if _, _, err := readFile(buf.Bytes()); err != ErrInvalidMagic {
+		t.Fatalf("invalid magic: got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package cmpfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+//An encrypted compare file wraps the whole gzip compressed compare file, it is encrypted to X25519 public key of miner:
+//
+//	magic     [4]byte  "YTCE"
+//	version   uint16   version of encryption format, currently 1
+//	ephemeral [32]byte ephemeral X25519 public key of sender
+//	chunkSize uint32   max size of plaintext in each chunk
+//	chunks    chunks of AES-256-GCM ciphertext, each of at most chunkSize+16 bytes
+//
+//AES key is derived by HKDF-SHA256 from X25519 shared secret, with ephemeral public key followed by public key of miner as salt.
+//Nonce of each chunk is 0x01 for the last chunk or 0x00 for others, followed by 3 bytes zero and 8 bytes big endian chunk index,
+//all bytes of header are used as additional data of each chunk.
+
+const (
+	//EncryptedVersion current version of encryption format
+	EncryptedVersion uint16 = 1
+	//EncryptedHeaderSize size of header of encrypted compare file in bytes
+	EncryptedHeaderSize = 4 + 2 + 32 + 4
+	//KeySize size of X25519 public and private key in bytes
+	KeySize = 32
+	//ChunkSize max size of plaintext in each chunk
+	ChunkSize = 64 * 1024
+)
+
+//EncryptedMagic magic number of encrypted compare file
+var EncryptedMagic = [4]byte{'Y', 'T', 'C', 'E'}
+
+var (
+	//ErrNotEncrypted file is not an encrypted compare file
+	ErrNotEncrypted = errors.New("cmpfile: not encrypted")
+	//ErrDecryption file cannot be decrypted by the key, or is corrupted or truncated
+	ErrDecryption = errors.New("cmpfile: decryption failed")
+)
+
+//hkdfInfo info of HKDF for deriving AES key
+var hkdfInfo = []byte("yotta-compare cmpfile v1")
+
+//IsEncrypted whether data starting with prefix is an encrypted compare file
+func IsEncrypted(prefix []byte) bool {
+	return len(prefix) >= len(EncryptedMagic) && bytes.Equal(prefix[:len(EncryptedMagic)], EncryptedMagic[:])
+}
+
+//GenerateKey generate a new X25519 key pair for miner
+func GenerateKey(rand io.Reader) (publicKey, privateKey []byte, err error) {
+	var priv, pub [KeySize]byte
+	if _, err := io.ReadFull(rand, priv[:]); err != nil {
+		return nil, nil, err
+	}
+	curve25519.ScalarBaseMult(&pub, &priv)
+	return pub[:], priv[:], nil
+}
+
+//ParseKey parse base64 encoded X25519 public or private key
+func ParseKey(s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return b, nil
+}
+
+//PublicKey derive X25519 public key from private key
+func PublicKey(privateKey []byte) ([]byte, error) {
+	if len(privateKey) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	var priv, pub [KeySize]byte
+	copy(priv[:], privateKey)
+	curve25519.ScalarBaseMult(&pub, &priv)
+	return pub[:], nil
+}
+
+//newAEAD derive AES-256-GCM cipher from private key and peer public key
+func newAEAD(privateKey, peerKey, ephemeralKey, recipientKey []byte) (cipher.AEAD, error) {
+	if len(privateKey) != KeySize || len(peerKey) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	var priv, peer, shared [KeySize]byte
+	copy(priv[:], privateKey)
+	copy(peer[:], peerKey)
+	curve25519.ScalarMult(&shared, &priv, &peer)
+	if shared == [KeySize]byte{} {
+		return nil, ErrInvalidKey
+	}
+	salt := append(append([]byte{}, ephemeralKey...), recipientKey...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared[:], salt, hkdfInfo), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//chunkNonce nonce of chunk with index
+func chunkNonce(nonce []byte, index uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	if last {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+}
+
+//EncryptWriter writer encrypting compare file to public key of miner
+type EncryptWriter struct {
+	w             io.Writer
+	aead          cipher.AEAD
+	header        []byte
+	headerWritten bool
+	buf           []byte
+	out           []byte
+	nonce         []byte
+	index         uint64
+	closed        bool
+}
+
+//NewEncryptWriter create a new writer encrypting data to X25519 public key of miner, header is written along with the first chunk,
+//Close must be called for writing the last chunk
+func NewEncryptWriter(w io.Writer, rand io.Reader, publicKey []byte) (*EncryptWriter, error) {
+	ephemeralPub, ephemeralPriv, err := GenerateKey(rand)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(ephemeralPriv, publicKey, ephemeralPub, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, EncryptedHeaderSize)
+	copy(header[0:4], EncryptedMagic[:])
+	binary.BigEndian.PutUint16(header[4:6], EncryptedVersion)
+	copy(header[6:38], ephemeralPub)
+	binary.BigEndian.PutUint32(header[38:42], ChunkSize)
+	return &EncryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, ChunkSize), out: make([]byte, 0, ChunkSize+aead.Overhead()), nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+//flush encrypt and write buffered plaintext as one chunk
+func (writer *EncryptWriter) flush(last bool) error {
+	if !writer.headerWritten {
+		if _, err := writer.w.Write(writer.header); err != nil {
+			return err
+		}
+		writer.headerWritten = true
+	}
+	chunkNonce(writer.nonce, writer.index, last)
+	writer.out = writer.aead.Seal(writer.out[:0], writer.nonce, writer.buf, writer.header)
+	if _, err := writer.w.Write(writer.out); err != nil {
+		return err
+	}
+	writer.buf = writer.buf[:0]
+	writer.index++
+	return nil
+}
+
+//Write encrypt data, a chunk is written only when it is full and more data is coming, so that the last chunk is always written by Close
+func (writer *EncryptWriter) Write(p []byte) (int, error) {
+	if writer.closed {
+		return 0, io.ErrClosedPipe
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(writer.buf) == ChunkSize {
+			if err := writer.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(writer.buf[len(writer.buf):ChunkSize], p)
+		writer.buf = writer.buf[:len(writer.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+//Close write the last chunk, the underlying writer is not closed
+func (writer *EncryptWriter) Close() error {
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+	return writer.flush(true)
+}
+
+//DecryptReader reader decrypting encrypted compare file with private key of miner
+type DecryptReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	aad   []byte
+	chunk []byte
+	buf   []byte
+	plain []byte
+	nonce []byte
+	index uint64
+	eof   bool
+	err   error
+}
+
+//NewDecryptReader create a new reader decrypting data read from r with X25519 private key of miner, header is read and validated,
+//ErrNotEncrypted is returned if r is not an encrypted compare file
+func NewDecryptReader(r io.Reader, privateKey []byte) (*DecryptReader, error) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, EncryptedHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if !IsEncrypted(header) {
+		return nil, ErrNotEncrypted
+	}
+	if binary.BigEndian.Uint16(header[4:6]) != EncryptedVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	chunkSize := binary.BigEndian.Uint32(header[38:42])
+	if chunkSize == 0 || chunkSize > 16*1024*1024 {
+		return nil, ErrDecryption
+	}
+	publicKey, err := PublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(privateKey, header[6:38], header[6:38], publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptReader{r: reader, aead: aead, aad: header, chunk: make([]byte, int(chunkSize)+aead.Overhead()), buf: make([]byte, 0, chunkSize), nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+//next read and decrypt next chunk
+func (reader *DecryptReader) next() error {
+	n, err := io.ReadFull(reader.r, reader.chunk)
+	last := false
+	switch err {
+	case nil:
+		if _, err := reader.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return ErrDecryption
+	default:
+		return err
+	}
+	chunkNonce(reader.nonce, reader.index, last)
+	plain, err := reader.aead.Open(reader.buf[:0], reader.nonce, reader.chunk[:n], reader.aad)
+	if err != nil {
+		return ErrDecryption
+	}
+	reader.plain = plain
+	reader.index++
+	reader.eof = last
+	return nil
+}
+
+//Read read decrypted data, ErrDecryption is returned if data is corrupted, truncated or not encrypted to the key
+func (reader *DecryptReader) Read(p []byte) (int, error) {
+	for len(reader.plain) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		if reader.eof {
+			reader.err = io.EOF
+			return 0, reader.err
+		}
+		if err := reader.next(); err != nil {
+			reader.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, reader.plain)
+	reader.plain = reader.plain[n:]
+	return n, nil
+}
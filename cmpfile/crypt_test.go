@@ -0,0 +1,148 @@
+package cmpfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+//encrypt encrypt data to public key
+func encrypt(t *testing.T, publicKey, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, err := NewEncryptWriter(&buf, rand.Reader, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//decrypt decrypt all data with private key
+func decrypt(privateKey, data []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(bytes.NewReader(data), privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+//randomBytes n random bytes
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	publicKey, privateKey, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 2 * ChunkSize, 3*ChunkSize + 17} {
+		data := randomBytes(t, size)
+		encrypted := encrypt(t, publicKey, data)
+		if !IsEncrypted(encrypted) {
+			t.Fatalf("size %d: not recognized as encrypted", size)
+		}
+		chunks := (size + ChunkSize - 1) / ChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		if want := EncryptedHeaderSize + size + chunks*16; len(encrypted) != want {
+			t.Fatalf("size %d: encrypted size %d, want %d", size, len(encrypted), want)
+		}
+		decrypted, err := decrypt(privateKey, encrypted)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("size %d: decrypted data mismatch", size)
+		}
+	}
+}
+
+func TestEncryptSmallWrites(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKey(rand.Reader)
+	data := randomBytes(t, ChunkSize+100)
+	var buf bytes.Buffer
+	writer, _ := NewEncryptWriter(&buf, rand.Reader, publicKey)
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		writer.Write(data[i:end])
+	}
+	writer.Close()
+	decrypted, err := decrypt(privateKey, buf.Bytes())
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("decrypted data mismatch: %v", err)
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKey(rand.Reader)
+	encrypted := encrypt(t, publicKey, randomBytes(t, 2*ChunkSize))
+	chunk := ChunkSize + 16
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"chunk boundary", EncryptedHeaderSize + chunk},
+		{"header only", EncryptedHeaderSize},
+		{"inside last chunk", EncryptedHeaderSize + chunk + 100},
+		{"missing tag", len(encrypted) - 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := decrypt(privateKey, encrypted[:c.size]); err != ErrDecryption {
+				t.Fatalf("got %v, want %v", err, ErrDecryption)
+			}
+		})
+	}
+	if _, err := decrypt(privateKey, encrypted[:EncryptedHeaderSize-1]); err != ErrNotEncrypted {
+		t.Fatalf("truncated header: got %v, want %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKey(rand.Reader)
+	encrypted := encrypt(t, publicKey, randomBytes(t, ChunkSize+1))
+	for _, pos := range []int{10, EncryptedHeaderSize + 1, len(encrypted) - 1} {
+		tampered := append([]byte{}, encrypted...)
+		tampered[pos] ^= 1
+		if _, err := decrypt(privateKey, tampered); err != ErrDecryption {
+			t.Fatalf("byte %d tampered: got %v, want %v", pos, err, ErrDecryption)
+		}
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	publicKey, _, _ := GenerateKey(rand.Reader)
+	_, otherKey, _ := GenerateKey(rand.Reader)
+	encrypted := encrypt(t, publicKey, randomBytes(t, 1000))
+	if _, err := decrypt(otherKey, encrypted); err != ErrDecryption {
+		t.Fatalf("got %v, want %v", err, ErrDecryption)
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	_, privateKey, _ := GenerateKey(rand.Reader)
+	data := writeFile(t, &Header{Flags: FlagSortedByVHF}, []*Record{{VHF: []byte{1}}})
+	if IsEncrypted(data) {
+		t.Fatal("plain compare file recognized as encrypted")
+	}
+	if _, err := decrypt(privateKey, data); err != ErrNotEncrypted {
+		t.Fatalf("got %v, want %v", err, ErrNotEncrypted)
+	}
+}
@@ -0,0 +1,125 @@
+package cmpfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+//Reader reader of compare file
+type Reader struct {
+	gz     *gzip.Reader
+	r      io.Reader
+	hash   hash.Hash
+	header Header
+	read   uint64
+	buf    []byte
+	prev   []byte
+	record Record
+	err    error
+}
+
+//NewReader create a new compare file reader, header is read and validated
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	reader := &Reader{gz: gz, r: io.TeeReader(gz, h), hash: h}
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(reader.r, buf); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[0:4], Magic[:]) {
+		return nil, ErrInvalidMagic
+	}
+	reader.header.Version = binary.BigEndian.Uint16(buf[4:6])
+	if reader.header.Version == 0 || reader.header.Version > Version {
+		return nil, ErrUnsupportedVersion
+	}
+	reader.header.Flags = binary.BigEndian.Uint16(buf[6:8])
+	if err := reader.header.validate(); err != nil {
+		return nil, err
+	}
+	reader.header.MinerID = int32(binary.BigEndian.Uint32(buf[8:12]))
+	reader.header.Start = int64(binary.BigEndian.Uint64(buf[12:20]))
+	reader.header.Range = int64(binary.BigEndian.Uint64(buf[20:28]))
+	reader.header.Count = binary.BigEndian.Uint64(buf[28:36])
+	reader.header.VHFLen = binary.BigEndian.Uint16(buf[36:38])
+	if reader.header.VHFLen == 0 && reader.header.Count > 0 {
+		return nil, ErrRecordLength
+	}
+	reader.buf = make([]byte, reader.header.recordSize())
+	reader.prev = make([]byte, reader.header.recordSize())
+	return reader, nil
+}
+
+//Header header of compare file
+func (reader *Reader) Header() Header {
+	return reader.header
+}
+
+//Next read next record, the returned record is only valid until next call, ID and BlockID of record are 0 if FlagShardInfo is not set,
+//io.EOF is returned after all records are read and checksum is verified, ErrRecordOrder is returned if records are not in the order specified by flags
+func (reader *Reader) Next() (*Record, error) {
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if reader.read >= reader.header.Count {
+		reader.err = reader.verify()
+		return nil, reader.err
+	}
+	reader.buf, reader.prev = reader.prev, reader.buf
+	if _, err := io.ReadFull(reader.r, reader.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		reader.err = err
+		return nil, err
+	}
+	record := reader.decode(reader.buf)
+	if reader.read > 0 && reader.header.Sorted() && reader.header.Less(&record, &reader.record) {
+		reader.err = ErrRecordOrder
+		return nil, reader.err
+	}
+	reader.read++
+	reader.record = record
+	return &reader.record, nil
+}
+
+//decode decode record from buf
+func (reader *Reader) decode(buf []byte) Record {
+	if reader.header.Flags&FlagShardInfo != 0 {
+		return Record{ID: int64(binary.BigEndian.Uint64(buf[0:8])), BlockID: int64(binary.BigEndian.Uint64(buf[8:16])), VHF: buf[16:]}
+	}
+	return Record{VHF: buf}
+}
+
+//verify verify checksum at the end of file
+func (reader *Reader) verify() error {
+	sum := reader.hash.Sum(nil)
+	checksum := make([]byte, ChecksumSize)
+	if _, err := io.ReadFull(reader.gz, checksum); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if !bytes.Equal(sum, checksum) {
+		return ErrChecksumMismatch
+	}
+	if n, _ := reader.gz.Read(make([]byte, 1)); n > 0 {
+		return ErrRecordCount
+	}
+	return io.EOF
+}
+
+//Close close the reader, the underlying reader is not closed
+func (reader *Reader) Close() error {
+	return reader.gz.Close()
+}
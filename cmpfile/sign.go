@@ -0,0 +1,79 @@
+package cmpfile
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+//SignatureSuffix suffix of key of detached signature object, signature of object <minerID>_<fileFrom> is saved as <minerID>_<fileFrom>.sig
+const SignatureSuffix = ".sig"
+
+var (
+	//ErrInvalidSignature signature of compare file mismatch
+	ErrInvalidSignature = errors.New("cmpfile: invalid signature")
+	//ErrInvalidKey key is not a valid Ed25519 key
+	ErrInvalidKey = errors.New("cmpfile: invalid key")
+)
+
+//signedMessage message to be signed, which is magic number followed by SHA-256 of the whole uploaded compare file
+func signedMessage(digest []byte) []byte {
+	return append(append([]byte{}, Magic[:]...), digest...)
+}
+
+//Sign sign SHA-256 digest of uploaded compare file with Ed25519 private key, returns a 64 bytes signature,
+//the uploaded file is the compressed compare file, or the encrypted one if encryption is enabled
+func Sign(key ed25519.PrivateKey, digest []byte) []byte {
+	return ed25519.Sign(key, signedMessage(digest))
+}
+
+//Verify verify uploaded compare file read from r with Ed25519 public key and detached signature,
+//ErrInvalidSignature is returned if the file is not signed by the key
+func Verify(key ed25519.PublicKey, r io.Reader, sig []byte) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	return VerifyDigest(key, h.Sum(nil), sig)
+}
+
+//VerifyDigest verify SHA-256 digest of uploaded compare file with Ed25519 public key and detached signature
+func VerifyDigest(key ed25519.PublicKey, digest []byte, sig []byte) error {
+	if len(key) != ed25519.PublicKeySize {
+		return ErrInvalidKey
+	}
+	if !ed25519.Verify(key, signedMessage(digest), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+//ParsePrivateKey parse base64 encoded Ed25519 private key, both 32 bytes seed and 64 bytes private key are accepted
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, ErrInvalidKey
+	}
+}
+
+//ParsePublicKey parse base64 encoded Ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,119 @@
+package cmpfile
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+//generateKey generate a new Ed25519 key pair
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey, privateKey
+}
+
+//signFile sign data as uploaded compare file
+func signFile(key ed25519.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	return Sign(key, digest[:])
+}
+
+func TestSignVerify(t *testing.T) {
+	publicKey, privateKey := generateKey(t)
+	for _, size := range []int{0, 1, 4096} {
+		data := randomBytes(t, size)
+		sig := signFile(privateKey, data)
+		if len(sig) != ed25519.SignatureSize {
+			t.Fatalf("expect signature of %d bytes but got %d", ed25519.SignatureSize, len(sig))
+		}
+		if err := Verify(publicKey, bytes.NewReader(data), sig); err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		digest := sha256.Sum256(data)
+		if err := VerifyDigest(publicKey, digest[:], sig); err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	publicKey, privateKey := generateKey(t)
+	data := randomBytes(t, 1024)
+	sig := signFile(privateKey, data)
+	tampered := append([]byte{}, data...)
+	tampered[100] ^= 1
+	if err := Verify(publicKey, bytes.NewReader(tampered), sig); err != ErrInvalidSignature {
+		t.Fatalf("expect %v for tampered file but got %v", ErrInvalidSignature, err)
+	}
+	if err := Verify(publicKey, bytes.NewReader(data[:1000]), sig); err != ErrInvalidSignature {
+		t.Fatalf("expect %v for truncated file but got %v", ErrInvalidSignature, err)
+	}
+	badSig := append([]byte{}, sig...)
+	badSig[0] ^= 1
+	if err := Verify(publicKey, bytes.NewReader(data), badSig); err != ErrInvalidSignature {
+		t.Fatalf("expect %v for tampered signature but got %v", ErrInvalidSignature, err)
+	}
+	if err := Verify(publicKey, bytes.NewReader(data), sig[:32]); err != ErrInvalidSignature {
+		t.Fatalf("expect %v for short signature but got %v", ErrInvalidSignature, err)
+	}
+}
+
+func TestVerifyWrongKey(t *testing.T) {
+	_, privateKey := generateKey(t)
+	otherKey, _ := generateKey(t)
+	data := randomBytes(t, 1024)
+	sig := signFile(privateKey, data)
+	if err := Verify(otherKey, bytes.NewReader(data), sig); err != ErrInvalidSignature {
+		t.Fatalf("expect %v for wrong public key but got %v", ErrInvalidSignature, err)
+	}
+	if err := Verify(otherKey[:16], bytes.NewReader(data), sig); err != ErrInvalidKey {
+		t.Fatalf("expect %v for invalid public key but got %v", ErrInvalidKey, err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	publicKey, privateKey := generateKey(t)
+	encodedPublic := base64.StdEncoding.EncodeToString(publicKey)
+	for _, b := range [][]byte{privateKey.Seed(), privateKey} {
+		key, err := ParsePrivateKey(base64.StdEncoding.EncodeToString(b))
+		if err != nil {
+			t.Fatalf("parsing private key of %d bytes: %s", len(b), err)
+		}
+		if !bytes.Equal(key, privateKey) {
+			t.Fatalf("private key of %d bytes parsed incorrectly", len(b))
+		}
+		parsedPublic, err := ParsePublicKey(encodedPublic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := randomBytes(t, 100)
+		if err := Verify(parsedPublic, bytes.NewReader(data), signFile(key, data)); err != nil {
+			t.Fatalf("verifying file signed by private key of %d bytes: %s", len(b), err)
+		}
+	}
+	for _, n := range []int{0, 16, 31, 33, 63, 65} {
+		s := base64.StdEncoding.EncodeToString(randomBytes(t, n))
+		if _, err := ParsePrivateKey(s); err != ErrInvalidKey {
+			t.Fatalf("expect %v for private key of %d bytes but got %v", ErrInvalidKey, n, err)
+		}
+	}
+	for _, n := range []int{0, 16, 31, 33, 64} {
+		s := base64.StdEncoding.EncodeToString(randomBytes(t, n))
+		if _, err := ParsePublicKey(s); err != ErrInvalidKey {
+			t.Fatalf("expect %v for public key of %d bytes but got %v", ErrInvalidKey, n, err)
+		}
+	}
+	if _, err := ParsePrivateKey("not base64!"); err == nil {
+		t.Fatal("expect error for invalid base64 private key")
+	}
+	if _, err := ParsePublicKey("not base64!"); err == nil {
+		t.Fatal("expect error for invalid base64 public key")
+	}
+}
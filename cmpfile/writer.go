@@ -0,0 +1,111 @@
+package cmpfile
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"math"
+)
+
+//Writer writer of compare file
+type Writer struct {
+	gz            *gzip.Writer
+	w             io.Writer
+	hash          hash.Hash
+	header        Header
+	headerWritten bool
+	written       uint64
+	buf           []byte
+	last          Record
+}
+
+//NewWriter create a new compare file writer, header is written once the first record is written or writer is closed,
+//VHFLen of header is set by length of the first record
+func NewWriter(w io.Writer, header *Header) (*Writer, error) {
+	gz, err := gzip.NewWriterLevel(w, 7)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	hdr := *header
+	hdr.Version = Version
+	if err := hdr.validate(); err != nil {
+		return nil, err
+	}
+	return &Writer{gz: gz, w: io.MultiWriter(gz, h), hash: h, header: hdr}, nil
+}
+
+func (writer *Writer) writeHeader() error {
+	buf := make([]byte, HeaderSize)
+	copy(buf[0:4], Magic[:])
+	binary.BigEndian.PutUint16(buf[4:6], writer.header.Version)
+	binary.BigEndian.PutUint16(buf[6:8], writer.header.Flags)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(writer.header.MinerID))
+	binary.BigEndian.PutUint64(buf[12:20], uint64(writer.header.Start))
+	binary.BigEndian.PutUint64(buf[20:28], uint64(writer.header.Range))
+	binary.BigEndian.PutUint64(buf[28:36], writer.header.Count)
+	binary.BigEndian.PutUint16(buf[36:38], writer.header.VHFLen)
+	_, err := writer.w.Write(buf)
+	writer.headerWritten = true
+	return err
+}
+
+//WriteRecord write one record, shard ID and block ID of record are written only if FlagShardInfo is set,
+//ErrRecordOrder is returned if records are not written in the order specified by flags
+func (writer *Writer) WriteRecord(record *Record) error {
+	if !writer.headerWritten {
+		if len(record.VHF) == 0 || len(record.VHF) > math.MaxUint16 {
+			return ErrRecordLength
+		}
+		writer.header.VHFLen = uint16(len(record.VHF))
+		writer.buf = make([]byte, writer.header.recordSize())
+		if err := writer.writeHeader(); err != nil {
+			return err
+		}
+	}
+	if len(record.VHF) != int(writer.header.VHFLen) {
+		return ErrRecordLength
+	}
+	if writer.written >= writer.header.Count {
+		return ErrRecordCount
+	}
+	if writer.header.Sorted() {
+		if writer.written > 0 && writer.header.Less(record, &writer.last) {
+			return ErrRecordOrder
+		}
+		writer.last.ID = record.ID
+		writer.last.VHF = append(writer.last.VHF[:0], record.VHF...)
+	}
+	if writer.header.Flags&FlagShardInfo != 0 {
+		binary.BigEndian.PutUint64(writer.buf[0:8], uint64(record.ID))
+		binary.BigEndian.PutUint64(writer.buf[8:16], uint64(record.BlockID))
+		copy(writer.buf[16:], record.VHF)
+	} else {
+		copy(writer.buf, record.VHF)
+	}
+	_, err := writer.w.Write(writer.buf)
+	if err != nil {
+		return err
+	}
+	writer.written++
+	return nil
+}
+
+//Close write checksum and flush all data, the underlying writer is not closed
+func (writer *Writer) Close() error {
+	if !writer.headerWritten {
+		if err := writer.writeHeader(); err != nil {
+			return err
+		}
+	}
+	if writer.written != writer.header.Count {
+		return ErrRecordCount
+	}
+	_, err := writer.gz.Write(writer.hash.Sum(nil))
+	if err != nil {
+		return err
+	}
+	return writer.gz.Close()
+}
@@ -4,78 +4,165 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
-	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"gopkg.in/mgo.v2/bson"
-
-	"github.com/tencentyun/cos-go-sdk-v5"
+	"github.com/yottachain/yotta-compare/cmpfile"
 )
 
 //Compare compare struct
 type Compare struct {
-	httpCli   *http.Client
-	dbCli     *mongo.Client
-	cosCli    *cos.Client
-	dbName    string
-	SyncURLs  []string
-	StartTime int
-	TimeRange int
-	WaitTime  int
-	SkipTime  int
+	state             StateStore
+	Source            ShardSource
+	Storage           ObjectStore
+	SyncURLs          []string
+	StartTime         int
+	TimeRange         int
+	WaitTime          int
+	SkipTime          int
+	RetryTimes        int
+	backoff           *Backoff
+	spillDir          string
+	budget            *MemoryBudget
+	flags             uint16
+	signKey           ed25519.PrivateKey
+	encrypt           bool
+	allowPlaintext    bool
+	chainMode         string
+	manifestMaxFiles  int
+	shutdownTimeout   time.Duration
+	catchUpWindows    int
+	uploadConcurrency int
+	minTimeRange      int64
+	maxTimeRange      int64
+	targetShards      int64
 }
 
 //New create a new Compare instance
 func New(ctx context.Context, config *Config) (*Compare, error) {
 	entry := log.WithFields(log.Fields{Function: "New"})
-	dbClient, err := mongo.Connect(ctx, options.Client().ApplyURI(config.MongoDBURL))
+	if config.Encryption.Enabled && config.Encryption.KeyCollection == "" {
+		err := errors.New("key collection of encryption is empty")
+		entry.WithError(err).Error("creating compare service failed")
+		return nil, err
+	}
+	state, err := NewStateStore(ctx, config)
 	if err != nil {
-		entry.WithError(err).Errorf("creating mongo DB client failed: %s", config.MongoDBURL)
+		entry.WithError(err).Errorf("creating state store failed: %s", config.StateStore)
 		return nil, err
 	}
-	COSURL := fmt.Sprintf("%s://%s.%s", config.COS.Schema, config.COS.BucketName, config.COS.Domain)
-	u, err := url.Parse(COSURL)
+	storage, err := NewObjectStore(config)
 	if err != nil {
-		entry.WithError(err).Errorf("parse COS URL failed: %s", COSURL)
+		entry.WithError(err).Errorf("creating object store failed: %s", config.StorageType)
 		return nil, err
 	}
-	cosClient := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
-		Transport: &cos.AuthorizationTransport{
-			SecretID:  config.COS.SecretID,
-			SecretKey: config.COS.SecretKey,
-		},
-	})
-	return &Compare{httpCli: &http.Client{}, dbCli: dbClient, cosCli: cosClient, dbName: config.DBName, SyncURLs: config.AllSyncURLs, StartTime: config.StartTime, TimeRange: config.TimeRange, WaitTime: config.WaitTime, SkipTime: config.SkipTime}, nil
+	if fsStore, ok := storage.(*FSStore); ok && config.FS.BindAddr != "" {
+		err := fsStore.Serve(config.FS.BindAddr)
+		if err != nil {
+			entry.WithError(err).Errorf("starting HTTP server of filesystem store failed: %s", config.FS.BindAddr)
+			return nil, err
+		}
+	}
+	var flags uint16
+	switch strings.ToLower(config.RecordMode) {
+	case RecordModeVHF, "":
+		flags |= cmpfile.FlagSortedByVHF
+	case RecordModeFull:
+		flags |= cmpfile.FlagShardInfo | cmpfile.FlagSortedByID
+	default:
+		err := fmt.Errorf("no such record mode: %s", config.RecordMode)
+		entry.WithError(err).Error("creating compare service failed")
+		return nil, err
+	}
+	var signKey ed25519.PrivateKey
+	if config.SignKey != "" {
+		signKey, err = cmpfile.ParsePrivateKey(config.SignKey)
+		if err != nil {
+			entry.WithError(err).Error("parsing sign key failed")
+			return nil, err
+		}
+		entry.Infof("compare files are signed by public key %s", base64.StdEncoding.EncodeToString(signKey.Public().(ed25519.PublicKey)))
+	}
+	chainMode := strings.ToLower(config.ChainMode)
+	switch chainMode {
+	case "":
+		chainMode = ChainModeTag
+	case ChainModeTag, ChainModeManifest, ChainModeBoth:
+	default:
+		err := fmt.Errorf("no such chain mode: %s", config.ChainMode)
+		entry.WithError(err).Error("creating compare service failed")
+		return nil, err
+	}
+	catchUpWindows := config.CatchUpWindows
+	if catchUpWindows < 1 {
+		catchUpWindows = 1
+	}
+	minTimeRange, maxTimeRange := int64(config.MinTimeRange), int64(config.MaxTimeRange)
+	if minTimeRange <= 0 {
+		minTimeRange = int64(config.TimeRange)
+	}
+	if maxTimeRange <= 0 {
+		maxTimeRange = int64(config.TimeRange)
+	}
+	if config.TargetShards > 0 && (minTimeRange <= 0 || minTimeRange > maxTimeRange) {
+		err := fmt.Errorf("invalid range of window: [%d, %d]", minTimeRange, maxTimeRange)
+		entry.WithError(err).Error("creating compare service failed")
+		return nil, err
+	}
+	var budget *MemoryBudget
+	if config.Spill.MemoryLimit > 0 {
+		budget = NewMemoryBudget(int64(config.Spill.MemoryLimit) * 1024 * 1024)
+	}
+	return &Compare{state: state, Storage: storage, Source: NewHTTPShardSource(&http.Client{Timeout: time.Duration(config.RequestTimeout) * time.Second}, config.AllSyncURLs, config.PageSize), SyncURLs: config.AllSyncURLs, StartTime: config.StartTime, TimeRange: config.TimeRange, WaitTime: config.WaitTime, SkipTime: config.SkipTime, RetryTimes: config.RetryTimes, backoff: &Backoff{Base: time.Duration(config.RetryInterval) * time.Second, Max: time.Duration(config.RetryMaxInterval) * time.Second}, spillDir: config.Spill.Dir, budget: budget, flags: flags, signKey: signKey, encrypt: config.Encryption.Enabled, allowPlaintext: config.Encryption.AllowPlaintext, chainMode: chainMode, manifestMaxFiles: config.ManifestMaxFiles, shutdownTimeout: time.Duration(config.ShutdownTimeout) * time.Second, catchUpWindows: catchUpWindows, uploadConcurrency: config.UploadConcurrency, minTimeRange: minTimeRange, maxTimeRange: maxTimeRange, targetShards: config.TargetShards}, nil
 }
 
-//Start start compare service
+//Start start compare service, it returns after ctx is cancelled, the window being uploaded is still committed if all uploads finish within shutdown timeout
 func (compare *Compare) Start(ctx context.Context) {
 	entry := log.WithFields(log.Fields{Function: "Start"})
-	urls := compare.SyncURLs
-	snCount := len(urls)
-	checkPointTab := compare.dbCli.Database(compare.dbName).Collection(CheckPointTab)
 	entry.Info("compare service starting")
-	store := NewStore()
+	workCtx, cancelWork := compare.workContext(ctx)
+	defer cancelWork()
+	//fetching stage of pipeline, windows fetched in background are received from windows channel in order of start time
+	var pipeline *fetchPipeline
+	//window being uploaded, it is kept until committed so that uploading can be retried without fetching again
+	var window *windowFetch
+	defer func() {
+		compare.stopPipeline(pipeline)
+		compare.discardWindows([]*windowFetch{window})
+	}()
 	for {
+		if ctx.Err() != nil {
+			entry.Info("compare service stopped")
+			return
+		}
+		err := compare.state.Recover(ctx)
+		if err != nil {
+			entry.WithError(err).Error("recovering from journal")
+			sleep(ctx, time.Duration(compare.WaitTime)*time.Second)
+			continue
+		}
 		var checkPointOld *CheckPoint
-		checkPoint := new(CheckPoint)
-		err := checkPointTab.FindOne(ctx, bson.M{"_id": 1}).Decode(checkPoint)
+		checkPoint, err := compare.state.GetCheckPoint(ctx)
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				checkPoint = &CheckPoint{ID: 1, Start: int64(compare.StartTime), Range: int64(compare.TimeRange), Timestamp: time.Now().Unix()}
+			if err == ErrStateNotFound {
+				checkPoint = &CheckPoint{ID: 1, Start: int64(compare.StartTime), Range: compare.initialRange(), Timestamp: time.Now().Unix()}
 				entry.Debugf("no checkpoint record")
 			} else {
 				entry.WithError(err).Error("fetch checkpoint record")
-				time.Sleep(time.Duration(compare.WaitTime) * time.Second)
+				sleep(ctx, time.Duration(compare.WaitTime)*time.Second)
 				continue
 			}
 		} else {
@@ -84,115 +171,547 @@ func (compare *Compare) Start(ctx context.Context) {
 			checkPointOld.Start = checkPoint.Start
 			checkPointOld.Range = checkPoint.Range
 			checkPointOld.Timestamp = checkPoint.Timestamp
+			checkPointOld.Shards = checkPoint.Shards
 			checkPoint.Start = checkPointOld.Start + checkPointOld.Range
-			checkPoint.Range = int64(compare.TimeRange)
+			checkPoint.Range = compare.nextRange(checkPointOld.Range, checkPointOld.Shards)
+			checkPoint.Shards = 0
 			checkPoint.Timestamp = time.Now().Unix()
 			entry.Debugf("old checkpoint: %+v", checkPointOld)
 			entry.Debugf("new checkpoint: %+v", checkPoint)
 		}
 
-		if checkPoint.Start+checkPoint.Range > time.Now().Unix()-int64(compare.SkipTime) {
-			entry.Debugf("time invalid: %d", checkPoint.Start+checkPoint.Range)
-			time.Sleep(time.Duration(compare.WaitTime) * time.Second)
+		if window == nil {
+			if pipeline == nil {
+				pipeline = compare.startPipeline(ctx, checkPoint.Start, checkPoint.Range)
+			}
+			select {
+			case window = <-pipeline.windows:
+			case <-ctx.Done():
+			}
+			if window == nil {
+				continue
+			}
+		}
+		if window.start != checkPoint.Start {
+			entry.Warnf("checkpoint %d does not match fetched window %d, restarting fetching", checkPoint.Start, window.start)
+			compare.stopPipeline(pipeline)
+			pipeline = nil
+			compare.discardWindows([]*windowFetch{window})
+			window = nil
+			continue
+		}
+		//range of window is decided by fetching stage according to shards in previous windows
+		checkPoint.Range = window.timeRange
+		checkPoint.Shards = window.shards
+		if len(window.conflicts) > 0 {
+			entry.Warnf("%d shards assigned differently by SNs from %d to %d", len(window.conflicts), checkPoint.Start, checkPoint.Start+checkPoint.Range)
+			err := compare.state.SaveConflicts(workCtx, window.conflicts)
+			if err != nil {
+				//window is not committed until its conflicts are saved, otherwise they are lost since the window is never fetched again
+				entry.WithError(err).Errorf("saving conflict records from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+				sleep(ctx, time.Duration(compare.WaitTime)*time.Second)
+				continue
+			}
+			window.conflicts = nil
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		entry.Infof("uploading compare data from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+		errs := compare.uploadWindow(workCtx, window)
+		if len(errs) > 0 {
+			failed := make([]int32, 0, len(errs))
+			for nid := range errs {
+				failed = append(failed, nid)
+			}
+			sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+			entry.Warnf("uploading compare data from %d to %d failed for %d miners: %v", checkPoint.Start, checkPoint.Start+checkPoint.Range, len(failed), failed)
+			sleep(ctx, time.Duration(compare.WaitTime)*time.Second)
+			entry.Warnf("retry uploading shards from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
 			continue
 		}
+		if len(window.skipped) > 0 {
+			entry.Warnf("%d miners skipped from %d to %d since they have no public key: %v", len(window.skipped), checkPoint.Start, checkPoint.Start+checkPoint.Range, window.skipped)
+		}
+		err = compare.putWindowIndex(workCtx, newWindowIndex(checkPoint.Start, checkPoint.Range, window.files, window.skipped))
+		if err != nil {
+			//window is not committed without its index, since a missing index means the window is not finished
+			entry.WithError(err).Errorf("uploading window index from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+			sleep(ctx, time.Duration(compare.WaitTime)*time.Second)
+			continue
+		}
+		err = compare.state.Commit(workCtx, newJournal(checkPoint, window.cursors))
+		if err != nil {
+			entry.WithError(err).Errorf("committing window from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+			sleep(ctx, time.Duration(compare.WaitTime)*time.Second)
+			continue
+		}
+		compare.clearStores(window.store)
+		window = nil
+		entry.Infof("window from %d to %d committed", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+	}
+}
+
+//windowFetch shards of one window fetched in background
+type windowFetch struct {
+	start     int64
+	timeRange int64
+	//count of shards of all miners in the window, including duplicates
+	shards    int64
+	store     ShardStore
+	conflicts []*Conflict
+	err       error
+	done      chan struct{}
+	//miners whose compare files have been uploaded, along with the uploaded files and new cursors,
+	//they are kept across retries so that only failed miners are uploaded again
+	uploaded map[int32]bool
+	files    map[int32]*ManifestFile
+	cursors  map[int32]*Cursor
+	//miners skipped in the window since they have no public key for encryption, their cursors are not advanced
+	skipped []int32
+}
 
-		entry.Infof("fetching shards from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+//fetchWindowAsync start fetching shards of window [start, start+timeRange) in background, done channel of the returned window is closed when finished
+func (compare *Compare) fetchWindowAsync(ctx context.Context, start, timeRange int64) *windowFetch {
+	window := &windowFetch{start: start, timeRange: timeRange, done: make(chan struct{}), uploaded: make(map[int32]bool), files: make(map[int32]*ManifestFile), cursors: make(map[int32]*Cursor)}
+	go func() {
+		defer close(window.done)
+		window.store, window.conflicts, window.err = compare.fetchWindow(ctx, start, timeRange)
+		if window.err == nil {
+			for _, nid := range window.store.Miners() {
+				window.shards += window.store.Count(nid)
+			}
+		}
+	}()
+	return window
+}
+
+//fetchPipeline fetching stage of compare service, windows are fetched in background and passed to uploading stage in order
+type fetchPipeline struct {
+	windows chan *windowFetch
+	cancel  context.CancelFunc
+}
+
+//startPipeline start fetching windows in background from window [start, start+timeRange), at most catchUpWindows windows are fetched concurrently,
+//next window is fetched while the previous one is being uploaded, windows channel is closed when ctx is done
+func (compare *Compare) startPipeline(ctx context.Context, start, timeRange int64) *fetchPipeline {
+	ctx, cancel := context.WithCancel(ctx)
+	pipeline := &fetchPipeline{windows: make(chan *windowFetch), cancel: cancel}
+	go compare.fetchLoop(ctx, start, timeRange, pipeline.windows)
+	return pipeline
+}
+
+//stopPipeline stop fetching and clear windows fetched but not received
+func (compare *Compare) stopPipeline(pipeline *fetchPipeline) {
+	if pipeline == nil {
+		return
+	}
+	pipeline.cancel()
+	for window := range pipeline.windows {
+		compare.clearStores(window.store)
+	}
+}
+
+//fetchLoop fetch windows in order and send them to out, a window is fetched only when it is at least skip time earlier than now,
+//range of windows not yet scheduled is adapted to shards of the latest fetched window
+func (compare *Compare) fetchLoop(ctx context.Context, start, timeRange int64, out chan<- *windowFetch) {
+	entry := log.WithFields(log.Fields{Function: "fetchLoop"})
+	//windows being fetched or fetched but not sent, in order of start time
+	var fetching []*windowFetch
+	defer func() {
+		compare.discardWindows(fetching)
+		close(out)
+	}()
+	for {
+		for len(fetching) < compare.catchUpWindows && start+timeRange <= time.Now().Unix()-int64(compare.SkipTime) {
+			fetching = append(fetching, compare.fetchWindowAsync(ctx, start, timeRange))
+			start += timeRange
+		}
+		if len(fetching) == 0 {
+			entry.Debugf("time invalid: %d", start+timeRange)
+			if !sleep(ctx, time.Duration(compare.WaitTime)*time.Second) {
+				return
+			}
+			continue
+		}
+		window := fetching[0]
+		select {
+		case <-window.done:
+		case <-ctx.Done():
+			return
+		}
+		if window.err != nil {
+			return
+		}
+		timeRange = compare.nextRange(window.timeRange, window.shards)
+		select {
+		case out <- window:
+			fetching = fetching[1:]
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//discardWindows wait for fetching of windows to finish and clear their shards
+func (compare *Compare) discardWindows(windows []*windowFetch) {
+	for _, window := range windows {
+		if window == nil {
+			continue
+		}
+		<-window.done
+		compare.clearStores(window.store)
+	}
+}
+
+//fetchWindow fetch shards of all SNs in window [start, start+timeRange) and merge them into one store, conflicts of shards between SNs are also returned,
+//SNs failed after retries are fetched again after wait time, shards of other SNs are kept, until all SNs are fetched or ctx is done
+func (compare *Compare) fetchWindow(ctx context.Context, start, timeRange int64) (ShardStore, []*Conflict, error) {
+	entry := log.WithFields(log.Fields{Function: "fetchWindow"})
+	snCount := compare.Source.SNCount()
+	//shards fetched from each SN, nil if not fetched successfully
+	snStores := make([]ShardStore, snCount)
+	//index of shards of all SNs for detecting conflicts
+	index := NewShardIndex(compare.budget)
+	defer index.Clear()
+	for {
+		entry.Infof("fetching shards from %d to %d", start, start+timeRange)
 		var wg sync.WaitGroup
-		wg.Add(snCount)
-		var innerErr *error
 		for i := 0; i < snCount; i++ {
+			if snStores[i] != nil {
+				continue
+			}
 			snID := int32(i)
+			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				entry := log.WithFields(log.Fields{Function: "Start", SNID: snID})
-				entry.Debugf("starting fetching shards in SN%d from %d to %d", snID, checkPoint.Start, checkPoint.Start+checkPoint.Range)
-				shards, err := GetCompareShards(compare.httpCli, urls[snID], checkPoint.Start, checkPoint.Start+checkPoint.Range)
-				if err != nil {
-					innerErr = &err
-					entry.WithError(err).Error("fetch compare shards")
-					return
-				}
-				for _, shard := range shards {
-					store.Add(shard.NodeID, shard.VHF)
+				snStore, err := compare.fetchShards(ctx, index, snID, start, start+timeRange)
+				if err == nil {
+					snStores[snID] = snStore
 				}
 			}()
 		}
 		wg.Wait()
-		if innerErr != nil {
-			store.Clear()
-			time.Sleep(time.Duration(compare.WaitTime) * time.Second)
-			entry.Warnf("retry fetching shards from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
-			continue
+		failed := 0
+		for _, snStore := range snStores {
+			if snStore == nil {
+				failed++
+			}
 		}
-		entry.Infof("uploading compare data from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
-		var wg2 sync.WaitGroup
-		wg2.Add(len(store.Items))
-		for id := range store.Items {
-			nid := id
-			go func() {
-				defer wg2.Done()
-				entry := log.WithFields(log.Fields{Function: "Start", MinerID: nid})
-				if len(store.Items[nid]) == 0 {
-					entry.Debugf("no compare data for uploading from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
-					return
-				}
-				entry.Debugf("starting generating compare data from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
-				data, err := store.GenerateData(nid)
-				if err != nil {
-					innerErr = &err
-					entry.WithError(err).Errorf("generating compare data from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
-					return
-				}
-				err = compare.UploadData(ctx, nid, data, checkPoint.Start, checkPoint.Range)
-				if err != nil {
-					innerErr = &err
-					entry.WithError(err).Errorf("uploading compare data from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
-					return
-				}
-			}()
+		if failed == 0 {
+			break
 		}
-		wg2.Wait()
-		if innerErr != nil {
-			store.Clear()
-			time.Sleep(time.Duration(compare.WaitTime) * time.Second)
-			entry.Warnf("retry uploading shards from %d to %d", checkPoint.Start, checkPoint.Start+checkPoint.Range)
+		if !sleep(ctx, time.Duration(compare.WaitTime)*time.Second) {
+			compare.clearStores(snStores...)
+			return nil, nil, ctx.Err()
+		}
+		entry.Warnf("retry fetching shards of %d SNs from %d to %d", failed, start, start+timeRange)
+	}
+	store := compare.newStore()
+	for _, snStore := range snStores {
+		err := store.Merge(snStore)
+		if err != nil {
+			entry.WithError(err).Error("merging shards of SNs")
+		}
+	}
+	return store, index.Conflicts(start, timeRange), nil
+}
+
+//workContext create context for uploading and committing windows, it is cancelled after shutdown timeout elapses since ctx is done,
+//so that uploads in flight are not interrupted by shutdown unless they take too long
+func (compare *Compare) workContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	workCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-workCtx.Done():
+			return
+		}
+		timer := time.NewTimer(compare.shutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.WithFields(log.Fields{Function: "workContext"}).Warnf("uploads not finished in %s after shutting down, aborting", compare.shutdownTimeout)
+			cancel()
+		case <-workCtx.Done():
+		}
+	}()
+	return workCtx, cancel
+}
+
+//Close release resources of compare service, HTTP server of filesystem store is stopped and state store is closed
+func (compare *Compare) Close(ctx context.Context) error {
+	if fsStore, ok := compare.Storage.(*FSStore); ok {
+		fsStore.Stop(compare.shutdownTimeout)
+	}
+	return compare.state.Close(ctx)
+}
+
+//initialRange range of the first window
+func (compare *Compare) initialRange() int64 {
+	return compare.clampRange(int64(compare.TimeRange))
+}
+
+//nextRange range of the window following a window of timeRange seconds containing shards shards, if target shards is configured the range is adapted
+//so that a window contains about targetShards shards, it changes by at most a factor of 2 between adjacent windows and is limited to [minTimeRange, maxTimeRange]
+func (compare *Compare) nextRange(timeRange, shards int64) int64 {
+	if compare.targetShards <= 0 {
+		return int64(compare.TimeRange)
+	}
+	next := compare.maxTimeRange
+	if shards > 0 {
+		next = timeRange * compare.targetShards / shards
+	}
+	if next > timeRange*2 {
+		next = timeRange * 2
+	}
+	if next < timeRange/2 {
+		next = timeRange / 2
+	}
+	return compare.clampRange(next)
+}
+
+//clampRange limit range of window to [minTimeRange, maxTimeRange] if target shards is configured
+func (compare *Compare) clampRange(timeRange int64) int64 {
+	if compare.targetShards <= 0 {
+		return timeRange
+	}
+	if timeRange < compare.minTimeRange {
+		return compare.minTimeRange
+	}
+	if timeRange > compare.maxTimeRange {
+		return compare.maxTimeRange
+	}
+	return timeRange
+}
+
+//newStore create a new shards store, shards are spilled to disk when memory limit is configured
+func (compare *Compare) newStore() ShardStore {
+	if compare.budget != nil {
+		return NewSpillStore(compare.spillDir, compare.budget, compare.recordLess)
+	}
+	return NewStore(compare.recordLess)
+}
+
+//recordLess order of records in compare file
+func (compare *Compare) recordLess(a, b *cmpfile.Record) bool {
+	header := cmpfile.Header{Flags: compare.flags}
+	return header.Less(a, b)
+}
+
+//clearStores clear shards stores and release resources
+func (compare *Compare) clearStores(stores ...ShardStore) {
+	entry := log.WithFields(log.Fields{Function: "clearStores"})
+	for _, store := range stores {
+		if store == nil {
 			continue
 		}
-		store.Clear()
-		if checkPointOld == nil {
-			_, err := checkPointTab.InsertOne(ctx, checkPoint)
-			if err != nil {
-				entry.WithError(err).Errorf("insert checkpoint record: %+v", checkPoint)
-			} else {
-				entry.Debugf("insert checkpoint record: %+v", checkPoint)
+		err := store.Clear()
+		if err != nil {
+			entry.WithError(err).Warn("clear shards store")
+		}
+	}
+}
+
+//fetchShards fetch shards of SN snID in window [from, to), retrying with exponential backoff on failure, all fetched shards are added to index
+func (compare *Compare) fetchShards(ctx context.Context, index *ShardIndex, snID int32, from int64, to int64) (ShardStore, error) {
+	entry := log.WithFields(log.Fields{Function: "fetchShards", SNID: snID})
+	for attempt := 0; ; attempt++ {
+		entry.Debugf("starting fetching shards in SN%d from %d to %d", snID, from, to)
+		store := compare.newStore()
+		err := compare.Source.FetchShards(ctx, snID, from, to, func(shard *Shard) error {
+			if len(shard.VHF) == 0 || len(shard.VHF) > math.MaxUint16 {
+				//such shard can never be written to compare file, so skip it instead of failing the window
+				entry.Errorf("shard %d of miner %d has invalid VHF of %d bytes, skipping", shard.ID, shard.NodeID, len(shard.VHF))
+				return nil
 			}
-		} else {
-			_, err := checkPointTab.UpdateOne(ctx, bson.M{"_id": checkPoint.ID}, bson.M{"$set": bson.M{"start": checkPoint.Start, "range": checkPoint.Range, "timestamp": checkPoint.Timestamp}})
-			if err != nil {
-				entry.WithError(err).Errorf("update checkpoint record: %+v", checkPoint)
-			} else {
-				entry.Debugf("update checkpoint record: %+v", checkPoint)
+			index.Add(snID, shard)
+			return store.Add(shard.NodeID, &cmpfile.Record{ID: shard.ID, BlockID: shard.BlockID, VHF: shard.VHF})
+		})
+		if err == nil {
+			return store, nil
+		}
+		compare.clearStores(store)
+		if attempt >= compare.RetryTimes {
+			entry.WithError(err).Errorf("fetch compare shards from %d to %d failed after %d retries", from, to, attempt)
+			return nil, err
+		}
+		wait := compare.backoff.Duration(attempt)
+		entry.WithError(err).Warnf("fetch compare shards from %d to %d failed, retry after %s", from, to, wait)
+		if !sleep(ctx, wait) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//uploadWindow upload compare files of miners in window by at most uploadConcurrency workers, miners uploaded in previous attempts are skipped,
+//errors of miners failed to upload are returned by miner ID
+func (compare *Compare) uploadWindow(ctx context.Context, window *windowFetch) map[int32]error {
+	var miners []int32
+	for _, nid := range window.store.Miners() {
+		if !window.uploaded[nid] {
+			miners = append(miners, nid)
+		}
+	}
+	workers := compare.uploadConcurrency
+	if workers <= 0 || workers > len(miners) {
+		workers = len(miners)
+	}
+	errs := make(map[int32]error)
+	var lock sync.Mutex
+	nids := make(chan int32)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for nid := range nids {
+				file, cursor, err := compare.uploadMiner(ctx, window.store, nid, window.start, window.timeRange)
+				lock.Lock()
+				if err == ErrNoMinerKey {
+					window.uploaded[nid] = true
+					window.skipped = append(window.skipped, nid)
+				} else if err != nil {
+					errs[nid] = err
+				} else {
+					window.uploaded[nid] = true
+					if file != nil {
+						window.files[nid] = file
+						window.cursors[nid] = cursor
+					}
+				}
+				lock.Unlock()
 			}
+		}()
+	}
+	for _, nid := range miners {
+		nids <- nid
+	}
+	close(nids)
+	wg.Wait()
+	sort.Slice(window.skipped, func(i, j int) bool { return window.skipped[i] < window.skipped[j] })
+	return errs
+}
+
+//uploadMiner generate compare file of miner from store and upload it, nil file and cursor are returned if nothing is uploaded
+func (compare *Compare) uploadMiner(ctx context.Context, store ShardStore, nid int32, start int64, timeRange int64) (*ManifestFile, *Cursor, error) {
+	entry := log.WithFields(log.Fields{Function: "uploadMiner", MinerID: nid})
+	if store.Count(nid) == 0 {
+		entry.Debugf("no compare data for uploading from %d to %d", start, start+timeRange)
+		return nil, nil, nil
+	}
+	entry.Debugf("starting generating compare data from %d to %d", start, start+timeRange)
+	key, err := compare.minerKey(ctx, nid)
+	if err == ErrNoMinerKey {
+		entry.Warnf("no public key of miner, skipping compare data from %d to %d", start, start+timeRange)
+		return nil, nil, err
+	}
+	if err != nil {
+		entry.WithError(err).Errorf("fetching public key of miner for compare data from %d to %d", start, start+timeRange)
+		return nil, nil, err
+	}
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := compare.generateData(store, nid, start, timeRange, key, writer)
+		if err != nil && err != io.ErrClosedPipe {
+			entry.WithError(err).Errorf("generating compare data from %d to %d", start, start+timeRange)
 		}
+		writer.CloseWithError(err)
+	}()
+	file, cursor, err := compare.UploadData(ctx, nid, reader, start, timeRange)
+	reader.Close()
+	<-done
+	if err != nil {
+		entry.WithError(err).Errorf("uploading compare data from %d to %d", start, start+timeRange)
+		return nil, nil, err
 	}
+	return file, cursor, nil
 }
 
-//UploadData update compare data to tencent COS
-func (compare *Compare) UploadData(ctx context.Context, nodeID int32, data bytes.Buffer, start int64, timeRange int64) error {
+//generateData write compare file of one miner to w, the file is encrypted to key if key is not nil,
+//VHFs of all records in compare file must have the same length, so records with VHF length different from most records are skipped
+func (compare *Compare) generateData(store ShardStore, nodeID int32, start int64, timeRange int64, key []byte, w io.Writer) error {
+	entry := log.WithFields(log.Fields{Function: "generateData", MinerID: nodeID})
+	//count of records by length of VHF
+	lengths := make(map[int]uint64)
+	err := store.Each(nodeID, func(record *cmpfile.Record) error {
+		lengths[len(record.VHF)]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var vhfLen int
+	var count, skipped uint64
+	for length, n := range lengths {
+		if length > 0 && (n > count || (n == count && length < vhfLen)) {
+			vhfLen = length
+			count = n
+		}
+		skipped += n
+	}
+	skipped -= count
+	if skipped > 0 {
+		entry.Errorf("%d records skipped from %d to %d since length of their VHF is not %d", skipped, start, start+timeRange, vhfLen)
+	}
+	if key != nil {
+		encryptWriter, err := cmpfile.NewEncryptWriter(w, rand.Reader, key)
+		if err != nil {
+			return err
+		}
+		err = compare.writeData(store, nodeID, start, timeRange, count, vhfLen, encryptWriter)
+		if err != nil {
+			return err
+		}
+		return encryptWriter.Close()
+	}
+	return compare.writeData(store, nodeID, start, timeRange, count, vhfLen, w)
+}
+
+//writeData write count records of one miner with VHF of vhfLen bytes to w as compare file, other records are skipped
+func (compare *Compare) writeData(store ShardStore, nodeID int32, start int64, timeRange int64, count uint64, vhfLen int, w io.Writer) error {
+	fileWriter, err := cmpfile.NewWriter(w, &cmpfile.Header{Flags: compare.flags, MinerID: nodeID, Start: start, Range: timeRange, Count: count})
+	if err != nil {
+		return err
+	}
+	err = store.Each(nodeID, func(record *cmpfile.Record) error {
+		if len(record.VHF) != vhfLen {
+			return nil
+		}
+		return fileWriter.WriteRecord(record)
+	})
+	if err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
+
+//UploadData upload compare data read from data to object store, returns info of the uploaded file and new cursor of miner,
+//the cursor is not written to database until the whole window is committed, so uploading of the same window can be repeated,
+//if the window has been uploaded for the miner the same file is overwritten, and if the cursor of miner is already beyond the window
+//nothing is uploaded and nil file and cursor are returned
+func (compare *Compare) UploadData(ctx context.Context, nodeID int32, data io.Reader, start int64, timeRange int64) (*ManifestFile, *Cursor, error) {
 	entry := log.WithFields(log.Fields{Function: "UploadData", MinerID: nodeID})
-	cursorTab := compare.dbCli.Database(compare.dbName).Collection(CursorTab)
 	var cursorOld *Cursor
-	cursor := new(Cursor)
-	err := cursorTab.FindOne(ctx, bson.M{"_id": nodeID}).Decode(cursor)
+	cursor, err := compare.state.GetCursor(ctx, nodeID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == ErrStateNotFound {
 			cursor = &Cursor{ID: nodeID, From: start, Range: timeRange, FileFrom: 0, Timestamp: time.Now().Unix()}
 			entry.Debugf("no cursor record")
 		} else {
 			entry.WithError(err).Error("fetch cursor record")
-			return err
+			return nil, nil, err
 		}
+	} else if cursor.From == start {
+		//window has been uploaded for this miner, overwrite the same file without tagging previous one again
+		entry.Infof("compare data from %d has been uploaded as %d_%d, overwriting", start, nodeID, cursor.FileFrom)
+		cursor.Range = timeRange
+		cursor.Timestamp = time.Now().Unix()
+	} else if cursor.From > start {
+		entry.Warnf("compare data from %d is older than cursor record %+v, skipping", start, cursor)
+		return nil, nil, nil
 	} else {
 		cursorOld = new(Cursor)
 		cursorOld.ID = cursor.ID
@@ -205,79 +724,119 @@ func (compare *Compare) UploadData(ctx context.Context, nodeID int32, data bytes
 		cursor.FileFrom = start
 		cursor.Timestamp = time.Now().Unix()
 	}
-	_, err = compare.cosCli.Object.Put(ctx, fmt.Sprintf("%d_%d", nodeID, cursor.FileFrom), bytes.NewReader(data.Bytes()), nil)
+	key := fmt.Sprintf("%d_%d", nodeID, cursor.FileFrom)
+	digest := &digestWriter{hash: sha256.New()}
+	data = io.TeeReader(data, digest)
+	err = compare.Storage.Put(ctx, key, data)
 	if err != nil {
-		entry.WithError(err).Errorf("uploading data to COS from %d, range %d", cursor.From, cursor.Range)
-		return err
+		entry.WithError(err).Errorf("uploading data to object store from %d, range %d", cursor.From, cursor.Range)
+		return nil, nil, err
 	}
-	entry.Debugf("uploading data to COS from %d, range %d", cursor.From, cursor.Range)
-	if cursorOld != nil {
-		opt := &cos.ObjectPutTaggingOptions{
-			TagSet: []cos.ObjectTaggingTag{
-				{
-					Key:   "next",
-					Value: fmt.Sprintf("%d_%d", nodeID, cursor.FileFrom),
-				},
-				{
-					Key:   "range",
-					Value: fmt.Sprintf("%d", cursorOld.Range),
-				},
-			},
-		}
-		_, err := compare.cosCli.Object.PutTagging(ctx, fmt.Sprintf("%d_%d", nodeID, cursorOld.FileFrom), opt)
+	entry.Debugf("uploading data to object store from %d, range %d", cursor.From, cursor.Range)
+	if compare.signKey != nil {
+		sig := cmpfile.Sign(compare.signKey, digest.hash.Sum(nil))
+		err := compare.Storage.Put(ctx, key+cmpfile.SignatureSuffix, bytes.NewReader(sig))
 		if err != nil {
-			entry.WithError(err).Errorf("tagging data of %s failed", fmt.Sprintf("%d_%d", nodeID, cursorOld.From))
-			return err
+			entry.WithError(err).Errorf("uploading signature of %s", key)
+			return nil, nil, err
 		}
-		_, err = cursorTab.UpdateOne(ctx, bson.M{"_id": cursor.ID}, bson.M{"$set": bson.M{"from": cursor.From, "range": cursor.Range, "fileFrom": cursor.FileFrom, "timestamp": cursor.Timestamp}})
+	}
+	file := newManifestFile(key, cursor, digest, compare.signKey != nil)
+	if compare.chainMode != ChainModeTag {
+		err := compare.updateManifest(ctx, nodeID, file)
 		if err != nil {
-			entry.WithError(err).Errorf("update cursor record: %+v", cursor)
-		} else {
-			entry.Debugf("update cursor record: %+v", cursor)
+			entry.WithError(err).Errorf("updating manifest of %s failed", key)
+			return nil, nil, err
 		}
-		return nil
 	}
-	_, err = cursorTab.InsertOne(ctx, cursor)
-	if err != nil {
-		entry.WithError(err).Errorf("insert cursor record: %+v", cursor)
-	} else {
-		entry.Debugf("insert cursor record: %+v", cursor)
+	if cursorOld != nil && cursorOld.FileFrom != cursor.FileFrom && compare.chainMode != ChainModeManifest {
+		tags := map[string]string{
+			"next":  key,
+			"range": fmt.Sprintf("%d", cursorOld.Range),
+		}
+		err := compare.Storage.PutTags(ctx, fmt.Sprintf("%d_%d", nodeID, cursorOld.FileFrom), tags)
+		if err != nil {
+			entry.WithError(err).Errorf("tagging data of %s failed", fmt.Sprintf("%d_%d", nodeID, cursorOld.From))
+			return nil, nil, err
+		}
 	}
-	return nil
+	return file, cursor, nil
 }
 
-//GetCompareShards find shards data for comparing
-func GetCompareShards(httpCli *http.Client, url string, from int64, to int64) ([]*Shard, error) {
+//GetCompareShards find shards data for comparing, the response is decoded as a stream and each shard is passed to handler as soon as it is decoded,
+//if limit is greater than 0, at most limit shards with ID greater than lastID are requested, returns count of decoded shards
+func GetCompareShards(ctx context.Context, httpCli *http.Client, url string, from int64, to int64, lastID int64, limit int, handler func(shard *Shard) error) (int64, error) {
 	entry := log.WithFields(log.Fields{Function: "GetCompareShards"})
 	fullURL := fmt.Sprintf("%s/sync/GetStoredShards?from=%d&to=%d", url, from, to)
+	if limit > 0 {
+		fullURL = fmt.Sprintf("%s&lastId=%d&limit=%d", fullURL, lastID, limit)
+	}
 	entry.Debugf("fetching compare data by URL: %s", fullURL)
-	request, err := http.NewRequest("GET", fullURL, nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
 	if err != nil {
 		entry.WithError(err).Errorf("create request failed: %s", fullURL)
-		return nil, err
+		return 0, err
 	}
 	request.Header.Add("Accept-Encoding", "gzip")
 	resp, err := httpCli.Do(request)
 	if err != nil {
 		entry.WithError(err).Errorf("get compare data failed: %s", fullURL)
-		return nil, err
+		return 0, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := &statusError{code: resp.StatusCode}
+		entry.WithError(err).Errorf("get compare data failed: %s", fullURL)
+		return 0, err
+	}
 	reader := io.Reader(resp.Body)
 	if strings.Contains(resp.Header.Get("Content-Encoding"), "gzip") {
 		gbuf, err := gzip.NewReader(reader)
 		if err != nil {
 			entry.WithError(err).Errorf("decompress response body: %s", fullURL)
-			return nil, err
+			return 0, err
 		}
 		reader = io.Reader(gbuf)
 		defer gbuf.Close()
 	}
-	response := make([]*Shard, 0)
-	err = json.NewDecoder(reader).Decode(&response)
+	count, err := decodeShards(reader, handler)
 	if err != nil {
 		entry.WithError(err).Errorf("decode compare data failed: %s", fullURL)
-		return nil, err
+		return count, err
+	}
+	entry.Debugf("decoded %d shards: %s", count, fullURL)
+	return count, nil
+}
+
+//decodeShards walk through JSON array of shards token by token, returns count of decoded shards
+func decodeShards(reader io.Reader, handler func(shard *Shard) error) (int64, error) {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, err
+	}
+	if token == nil {
+		return 0, nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return 0, fmt.Errorf("expect JSON array but got %v", token)
+	}
+	var count int64
+	for decoder.More() {
+		shard := new(Shard)
+		err := decoder.Decode(shard)
+		if err != nil {
+			return count, err
+		}
+		err = handler(shard)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return count, err
 	}
-	return response, nil
+	return count, nil
 }
@@ -0,0 +1,295 @@
+package ytcompare
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+//fakeShard shard stored in SN at time
+type fakeShard struct {
+	time  int64
+	shard *Shard
+}
+
+//fakeSource shard source of shards in memory, indexed by SN ID
+type fakeSource struct {
+	sns [][]fakeShard
+}
+
+func (source *fakeSource) SNCount() int {
+	return len(source.sns)
+}
+
+func (source *fakeSource) FetchShards(ctx context.Context, snID int32, from int64, to int64, handler func(shard *Shard) error) error {
+	for _, s := range source.sns[snID] {
+		if s.time < from || s.time >= to {
+			continue
+		}
+		shard := *s.shard
+		if err := handler(&shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//fakeVHF VHF of length bytes derived from shard ID
+func fakeVHF(id int64, length int) []byte {
+	return bytes.Repeat([]byte{byte(id)}, length)
+}
+
+func TestFetchWindowSkipsInvalidVHF(t *testing.T) {
+	source := &fakeSource{sns: [][]fakeShard{
+		{
+			{time: 1, shard: &Shard{ID: 1, NodeID: 1, VHF: fakeVHF(1, 32)}},
+			{time: 2, shard: &Shard{ID: 2, NodeID: 1}},
+		},
+		{
+			{time: 3, shard: &Shard{ID: 3, NodeID: 2, VHF: fakeVHF(3, 32)}},
+			{time: 4, shard: &Shard{ID: 4, NodeID: 2, VHF: []byte{}}},
+		},
+	}}
+	compare := &Compare{Source: source, backoff: &Backoff{Base: time.Millisecond, Max: time.Millisecond}, flags: cmpfile.FlagShardInfo | cmpfile.FlagSortedByID}
+	store, conflicts, err := compare.fetchWindow(context.Background(), 0, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compare.clearStores(store)
+	if len(conflicts) != 0 {
+		t.Fatalf("expect no conflicts but got %d", len(conflicts))
+	}
+	if store.Count(1) != 1 || store.Count(2) != 1 {
+		t.Fatalf("expect shards with empty VHF to be skipped, got %d and %d shards", store.Count(1), store.Count(2))
+	}
+}
+
+func TestGenerateDataSkipsMismatchedVHF(t *testing.T) {
+	compare := &Compare{flags: cmpfile.FlagShardInfo | cmpfile.FlagSortedByID}
+	store := NewStore(compare.recordLess)
+	//the first record has VHF of different length from most records
+	lengths := []int{16, 32, 32, 0, 32, 8}
+	for i, length := range lengths {
+		if err := store.Add(1, &cmpfile.Record{ID: int64(i + 1), BlockID: 1, VHF: fakeVHF(int64(i+1), length)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := compare.generateData(store, 1, 0, 60, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := cmpfile.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if header := reader.Header(); header.Count != 3 || header.VHFLen != 32 {
+		t.Fatalf("expect 3 records with VHF of 32 bytes, got %d records with VHF of %d bytes", header.Count, header.VHFLen)
+	}
+	var ids []int64
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, record.ID)
+	}
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 3 || ids[2] != 5 {
+		t.Fatalf("expect records 2, 3 and 5 but got %v", ids)
+	}
+}
+
+//flakyState state store failing some commits and the first saving of conflicts, every fourth commit fails before writing anything,
+//and every fourth commit since the second one fails after cursors and checkpoint are written, like applying journal failed
+type flakyState struct {
+	*BoltStateStore
+	lock    sync.Mutex
+	commits int
+	saves   int
+}
+
+func (state *flakyState) Commit(ctx context.Context, journal *Journal) error {
+	state.lock.Lock()
+	state.commits++
+	n := state.commits
+	state.lock.Unlock()
+	switch n % 4 {
+	case 1:
+		return errors.New("committing failed")
+	case 2:
+		if err := state.BoltStateStore.Commit(ctx, journal); err != nil {
+			return err
+		}
+		return errors.New("applying journal failed")
+	}
+	return state.BoltStateStore.Commit(ctx, journal)
+}
+
+func (state *flakyState) SaveConflicts(ctx context.Context, conflicts []*Conflict) error {
+	state.lock.Lock()
+	state.saves++
+	n := state.saves
+	state.lock.Unlock()
+	if n == 1 {
+		return errors.New("saving conflicts failed")
+	}
+	return state.BoltStateStore.SaveConflicts(ctx, conflicts)
+}
+
+//pipelineSource shards of seconds [0, seconds), one shard per second assigned to miners 1 to 3 in turn and stored in SN 0 or 1 alternately,
+//every seventh shard is also stored in the other SN, and shard 100000 is assigned to different miners by SNs at second 130
+func pipelineSource(seconds int64) *fakeSource {
+	source := &fakeSource{sns: make([][]fakeShard, 2)}
+	for t := int64(0); t < seconds; t++ {
+		shard := &Shard{ID: t + 1, NodeID: int32(t%3) + 1, VHF: fakeVHF(t+1, 32), BlockID: t / 10}
+		source.sns[t%2] = append(source.sns[t%2], fakeShard{time: t, shard: shard})
+		if t%7 == 0 {
+			source.sns[(t+1)%2] = append(source.sns[(t+1)%2], fakeShard{time: t, shard: shard})
+		}
+	}
+	source.sns[0] = append(source.sns[0], fakeShard{time: 130, shard: &Shard{ID: 100000, NodeID: 1, VHF: fakeVHF(100000, 32)}})
+	source.sns[1] = append(source.sns[1], fakeShard{time: 130, shard: &Shard{ID: 100000, NodeID: 2, VHF: fakeVHF(100000, 32)}})
+	return source
+}
+
+//expectedCounts count of distinct shards of each miner in each window of timeRange seconds
+func expectedCounts(source *fakeSource, timeRange int64) map[int32]map[int64]uint64 {
+	seen := make(map[int32]map[int64]bool)
+	counts := make(map[int32]map[int64]uint64)
+	for _, shards := range source.sns {
+		for _, s := range shards {
+			nid := s.shard.NodeID
+			if seen[nid] == nil {
+				seen[nid] = make(map[int64]bool)
+				counts[nid] = make(map[int64]uint64)
+			}
+			if seen[nid][s.shard.ID] {
+				continue
+			}
+			seen[nid][s.shard.ID] = true
+			counts[nid][s.time/timeRange*timeRange]++
+		}
+	}
+	return counts
+}
+
+func TestStartChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yotta-compare-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateStore, err := NewBoltStateStore(filepath.Join(dir, "state.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stateStore.Close(context.Background())
+	const timeRange, seconds = 60, 600
+	source := pipelineSource(seconds)
+	storage := NewMemObjectStore()
+	compare := &Compare{state: &flakyState{BoltStateStore: stateStore}, Source: source, Storage: storage, TimeRange: timeRange, RetryTimes: 3, backoff: &Backoff{}, spillDir: dir, budget: NewMemoryBudget(4096), flags: cmpfile.FlagShardInfo | cmpfile.FlagSortedByID, chainMode: ChainModeTag, shutdownTimeout: 5 * time.Second, catchUpWindows: 2, uploadConcurrency: 2}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	//stop the service once the first window without shards is committed
+	go func() {
+		for ctx.Err() == nil {
+			if checkPoint, err := stateStore.GetCheckPoint(ctx); err == nil && checkPoint.Start >= seconds {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	compare.Start(ctx)
+	checkPoint, err := stateStore.GetCheckPoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkPoint.Start < seconds || checkPoint.Start%timeRange != 0 || checkPoint.Range != timeRange {
+		t.Fatalf("unexpected checkpoint %+v", checkPoint)
+	}
+	counts := expectedCounts(source, timeRange)
+	for nid := int32(1); nid <= 3; nid++ {
+		//walk through compare files of miner by next tags
+		var starts []int64
+		key := fmt.Sprintf("%d_0", nid)
+		for {
+			reader, err := storage.Get(context.Background(), key)
+			if err != nil {
+				t.Fatalf("miner %d: getting %s: %s", nid, key, err)
+			}
+			fileReader, err := cmpfile.NewReader(reader)
+			if err != nil {
+				t.Fatalf("miner %d: reading %s: %s", nid, key, err)
+			}
+			header := fileReader.Header()
+			for {
+				_, err := fileReader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("miner %d: reading records of %s: %s", nid, key, err)
+				}
+			}
+			fileReader.Close()
+			if header.MinerID != nid || header.Range != timeRange || header.Count != counts[nid][header.Start] {
+				t.Fatalf("miner %d: expect %d records from %d but got header %+v", nid, counts[nid][header.Start], header.Start, header)
+			}
+			if len(starts) > 0 && header.Start != starts[len(starts)-1]+timeRange {
+				t.Fatalf("miner %d: gap between windows %d and %d", nid, starts[len(starts)-1], header.Start)
+			}
+			starts = append(starts, header.Start)
+			tags, err := storage.GetTags(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := tags["next"]
+			if next == "" {
+				break
+			}
+			if next == key {
+				t.Fatalf("miner %d: %s is tagged with itself as next", nid, key)
+			}
+			if tags["range"] != fmt.Sprintf("%d", timeRange) {
+				t.Fatalf("miner %d: unexpected range tag of %s: %s", nid, key, tags["range"])
+			}
+			key = next
+		}
+		if len(starts) != seconds/timeRange || starts[0] != 0 {
+			t.Fatalf("miner %d: expect %d files from 0 but got %v", nid, seconds/timeRange, starts)
+		}
+		cursor, err := stateStore.GetCursor(context.Background(), nid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := fmt.Sprintf("%d_%d", nid, cursor.FileFrom); last != key || cursor.From != starts[len(starts)-1] {
+			t.Fatalf("miner %d: cursor %+v does not match the last file %s", nid, cursor, key)
+		}
+	}
+	for start := int64(0); start <= checkPoint.Start; start += timeRange {
+		if _, err := storage.Get(context.Background(), windowIndexKey(start)); err != nil {
+			t.Fatalf("window index of %d: %s", start, err)
+		}
+	}
+	conflict := new(Conflict)
+	if err := stateStore.get(ConflictTab, conflictKey(120, 100000), conflict); err != nil {
+		t.Fatalf("conflict of shard 100000 not saved: %s", err)
+	}
+	if len(conflict.Assignments) != 2 {
+		t.Fatalf("expect 2 assignments of conflict but got %d", len(conflict.Assignments))
+	}
+}
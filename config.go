@@ -5,16 +5,62 @@ const (
 	MongoDBURLField = "mongodb-url"
 	//DBNameField field name of db-name
 	DBNameField = "db-name"
+	//StateStoreField Field name of state-store
+	StateStoreField = "state-store"
+	//BoltPathField Field name of bolt.path config
+	BoltPathField = "bolt.path"
 	//AllSyncURLsField Field name of all-sync-urls
 	AllSyncURLsField = "all-sync-urls"
 	//StartTimeField Field name of start-time
 	StartTimeField = "start-time"
 	//TimeRangeField Field name of time-range
 	TimeRangeField = "time-range"
+	//MinTimeRangeField Field name of min-time-range
+	MinTimeRangeField = "min-time-range"
+	//MaxTimeRangeField Field name of max-time-range
+	MaxTimeRangeField = "max-time-range"
+	//TargetShardsField Field name of target-shards
+	TargetShardsField = "target-shards"
 	//WaitTimeField Field name of wait-time
 	WaitTimeField = "wait-time"
 	//SkipTimeField Field name of skip-time
 	SkipTimeField = "skip-time"
+	//RetryTimesField Field name of retry-times
+	RetryTimesField = "retry-times"
+	//RetryIntervalField Field name of retry-interval
+	RetryIntervalField = "retry-interval"
+	//RetryMaxIntervalField Field name of retry-max-interval
+	RetryMaxIntervalField = "retry-max-interval"
+	//PageSizeField Field name of page-size
+	PageSizeField = "page-size"
+	//RequestTimeoutField Field name of request-timeout
+	RequestTimeoutField = "request-timeout"
+	//RecordModeField Field name of record-mode
+	RecordModeField = "record-mode"
+	//SignKeyField Field name of sign-key
+	SignKeyField = "sign-key"
+	//ChainModeField Field name of chain-mode
+	ChainModeField = "chain-mode"
+	//ManifestMaxFilesField Field name of manifest-max-files
+	ManifestMaxFilesField = "manifest-max-files"
+	//ShutdownTimeoutField Field name of shutdown-timeout
+	ShutdownTimeoutField = "shutdown-timeout"
+	//CatchUpWindowsField Field name of catch-up-windows
+	CatchUpWindowsField = "catch-up-windows"
+	//UploadConcurrencyField Field name of upload-concurrency
+	UploadConcurrencyField = "upload-concurrency"
+	//SpillMemoryLimitField Field name of spill.memory-limit config
+	SpillMemoryLimitField = "spill.memory-limit"
+	//SpillDirField Field name of spill.dir config
+	SpillDirField = "spill.dir"
+	//EncryptionEnabledField Field name of encryption.enabled config
+	EncryptionEnabledField = "encryption.enabled"
+	//EncryptionKeyCollectionField Field name of encryption.key-collection config
+	EncryptionKeyCollectionField = "encryption.key-collection"
+	//EncryptionAllowPlaintextField Field name of encryption.allow-plaintext config
+	EncryptionAllowPlaintextField = "encryption.allow-plaintext"
+	//StorageTypeField Field name of storage-type
+	StorageTypeField = "storage-type"
 
 	//COSSchemaField Field name of cos.schema config
 	COSSchemaField = "cos.schema"
@@ -27,6 +73,24 @@ const (
 	//COSSecretKeyField Field name of cos.secret-key config
 	COSSecretKeyField = "cos.secret-key"
 
+	//S3EndpointField Field name of s3.endpoint config
+	S3EndpointField = "s3.endpoint"
+	//S3RegionField Field name of s3.region config
+	S3RegionField = "s3.region"
+	//S3BucketNameField Field name of s3.bucket-name config
+	S3BucketNameField = "s3.bucket-name"
+	//S3AccessKeyField Field name of s3.access-key config
+	S3AccessKeyField = "s3.access-key"
+	//S3SecretKeyField Field name of s3.secret-key config
+	S3SecretKeyField = "s3.secret-key"
+	//S3UseSSLField Field name of s3.use-ssl config
+	S3UseSSLField = "s3.use-ssl"
+
+	//FSRootDirField Field name of fs.root-dir config
+	FSRootDirField = "fs.root-dir"
+	//FSBindAddrField Field name of fs.bind-addr config
+	FSBindAddrField = "fs.bind-addr"
+
 	//LoggerOutputField Field name of logger.output config
 	LoggerOutputField = "logger.output"
 	//LoggerFilePathField Field name of logger.file-path config
@@ -41,15 +105,55 @@ const (
 
 //Config system configuration
 type Config struct {
-	MongoDBURL  string     `mapstructure:"mongodb-url"`
-	DBName      string     `mapstructure:"db-name"`
-	AllSyncURLs []string   `mapstructure:"all-sync-urls"`
-	StartTime   int        `mapstructure:"start-time"`
-	TimeRange   int        `mapstructure:"time-range"`
-	WaitTime    int        `mapstructure:"wait-time"`
-	SkipTime    int        `mapstructure:"skip-time"`
-	COS         *COSConfig `mapstructure:"cos"`
-	Logger      *LogConfig `mapstructure:"logger"`
+	MongoDBURL        string            `mapstructure:"mongodb-url"`
+	DBName            string            `mapstructure:"db-name"`
+	StateStore        string            `mapstructure:"state-store"`
+	Bolt              *BoltConfig       `mapstructure:"bolt"`
+	AllSyncURLs       []string          `mapstructure:"all-sync-urls"`
+	StartTime         int               `mapstructure:"start-time"`
+	TimeRange         int               `mapstructure:"time-range"`
+	MinTimeRange      int               `mapstructure:"min-time-range"`
+	MaxTimeRange      int               `mapstructure:"max-time-range"`
+	TargetShards      int64             `mapstructure:"target-shards"`
+	WaitTime          int               `mapstructure:"wait-time"`
+	SkipTime          int               `mapstructure:"skip-time"`
+	RetryTimes        int               `mapstructure:"retry-times"`
+	RetryInterval     int               `mapstructure:"retry-interval"`
+	RetryMaxInterval  int               `mapstructure:"retry-max-interval"`
+	PageSize          int               `mapstructure:"page-size"`
+	RequestTimeout    int               `mapstructure:"request-timeout"`
+	RecordMode        string            `mapstructure:"record-mode"`
+	SignKey           string            `mapstructure:"sign-key"`
+	ChainMode         string            `mapstructure:"chain-mode"`
+	ManifestMaxFiles  int               `mapstructure:"manifest-max-files"`
+	ShutdownTimeout   int               `mapstructure:"shutdown-timeout"`
+	CatchUpWindows    int               `mapstructure:"catch-up-windows"`
+	UploadConcurrency int               `mapstructure:"upload-concurrency"`
+	Spill             *SpillConfig      `mapstructure:"spill"`
+	Encryption        *EncryptionConfig `mapstructure:"encryption"`
+	StorageType       string            `mapstructure:"storage-type"`
+	COS               *COSConfig        `mapstructure:"cos"`
+	S3                *S3Config         `mapstructure:"s3"`
+	FS                *FSConfig         `mapstructure:"fs"`
+	Logger            *LogConfig        `mapstructure:"logger"`
+}
+
+//BoltConfig configuration of embedded bbolt state store
+type BoltConfig struct {
+	Path string `mapstructure:"path"`
+}
+
+//SpillConfig configuration of spilling shards to disk
+type SpillConfig struct {
+	MemoryLimit int    `mapstructure:"memory-limit"`
+	Dir         string `mapstructure:"dir"`
+}
+
+//EncryptionConfig configuration of encrypting compare files to public keys of miners
+type EncryptionConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	KeyCollection  string `mapstructure:"key-collection"`
+	AllowPlaintext bool   `mapstructure:"allow-plaintext"`
 }
 
 //COSConfig configuration of tencent COS
@@ -61,6 +165,22 @@ type COSConfig struct {
 	SecretKey  string `mapstructure:"secret-key"`
 }
 
+//S3Config configuration of S3-compatible storage
+type S3Config struct {
+	Endpoint   string `mapstructure:"endpoint"`
+	Region     string `mapstructure:"region"`
+	BucketName string `mapstructure:"bucket-name"`
+	AccessKey  string `mapstructure:"access-key"`
+	SecretKey  string `mapstructure:"secret-key"`
+	UseSSL     bool   `mapstructure:"use-ssl"`
+}
+
+//FSConfig configuration of local filesystem storage
+type FSConfig struct {
+	RootDir  string `mapstructure:"root-dir"`
+	BindAddr string `mapstructure:"bind-addr"`
+}
+
 //LogConfig system log configuration
 type LogConfig struct {
 	Output       string `mapstructure:"output"`
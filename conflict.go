@@ -0,0 +1,98 @@
+package ytcompare
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+//indexEntryOverhead estimated memory used by one entry of shards index
+const indexEntryOverhead = 64
+
+//indexEntry first assignment of one shard seen in current window
+type indexEntry struct {
+	snID    int32
+	nodeID  int32
+	vhfHash uint64
+}
+
+//ShardIndex index of shards fetched from all SNs in one window, used for detecting shards assigned to different miners or VHFs by SNs
+type ShardIndex struct {
+	lock      sync.Mutex
+	budget    *MemoryBudget
+	size      int64
+	entries   map[int64]indexEntry
+	conflicts map[int64][]indexEntry
+}
+
+//NewShardIndex create a new shards index, memory used by entries is charged to budget if it is not nil,
+//so that spill stores sharing the budget spill earlier
+func NewShardIndex(budget *MemoryBudget) *ShardIndex {
+	return &ShardIndex{budget: budget, entries: make(map[int64]indexEntry), conflicts: make(map[int64][]indexEntry)}
+}
+
+func vhfHash(vhf []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(vhf)
+	return h.Sum64()
+}
+
+//Add add a shard fetched from SN snID, a conflict is recorded if the shard is assigned to another miner or VHF before
+func (index *ShardIndex) Add(snID int32, shard *Shard) {
+	e := indexEntry{snID: snID, nodeID: shard.NodeID, vhfHash: vhfHash(shard.VHF)}
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	old, ok := index.entries[shard.ID]
+	if !ok {
+		index.entries[shard.ID] = e
+		index.size += indexEntryOverhead
+		if index.budget != nil {
+			index.budget.acquire(indexEntryOverhead)
+		}
+		return
+	}
+	if old.nodeID == e.nodeID && old.vhfHash == e.vhfHash {
+		return
+	}
+	conflicts := index.conflicts[shard.ID]
+	if len(conflicts) == 0 {
+		conflicts = append(conflicts, old)
+	}
+	for _, c := range conflicts {
+		if c == e {
+			return
+		}
+	}
+	index.conflicts[shard.ID] = append(conflicts, e)
+}
+
+//Conflicts all conflicts detected in window, sorted by shard ID
+func (index *ShardIndex) Conflicts(start, timeRange int64) []*Conflict {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	conflicts := make([]*Conflict, 0, len(index.conflicts))
+	now := time.Now().Unix()
+	for shardID, entries := range index.conflicts {
+		conflict := &Conflict{ShardID: shardID, Start: start, Range: timeRange, Timestamp: now}
+		for _, e := range entries {
+			conflict.Assignments = append(conflict.Assignments, &Assignment{SNID: e.snID, NodeID: e.nodeID, VHFHash: fmt.Sprintf("%016x", e.vhfHash)})
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].ShardID < conflicts[j].ShardID })
+	return conflicts
+}
+
+//Clear clear all entries and conflicts and release memory charged to budget
+func (index *ShardIndex) Clear() {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	if index.budget != nil {
+		index.budget.release(index.size)
+	}
+	index.size = 0
+	index.entries = make(map[int64]indexEntry)
+	index.conflicts = make(map[int64][]indexEntry)
+}
@@ -0,0 +1,152 @@
+package ytcompare
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+//cosPartSize part size of multipart upload
+const cosPartSize = 8 * 1024 * 1024
+
+//COSStore object store of tencent COS
+type COSStore struct {
+	cosCli *cos.Client
+}
+
+var _ ObjectStore = (*COSStore)(nil)
+
+//NewCOSStore create a new COSStore instance
+func NewCOSStore(config *COSConfig) (*COSStore, error) {
+	entry := log.WithFields(log.Fields{Function: "NewCOSStore"})
+	COSURL := fmt.Sprintf("%s://%s.%s", config.Schema, config.BucketName, config.Domain)
+	u, err := url.Parse(COSURL)
+	if err != nil {
+		entry.WithError(err).Errorf("parse COS URL failed: %s", COSURL)
+		return nil, err
+	}
+	cosClient := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
+		Transport: &cos.AuthorizationTransport{
+			SecretID:  config.SecretID,
+			SecretKey: config.SecretKey,
+		},
+	})
+	return &COSStore{cosCli: cosClient}, nil
+}
+
+//Put upload an object, multipart upload is used if size of object exceeds one part
+func (store *COSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, cosPartSize))
+	if err != nil {
+		return err
+	}
+	if n < cosPartSize {
+		_, err := store.cosCli.Object.Put(ctx, key, bytes.NewReader(buf.Bytes()), nil)
+		return err
+	}
+	return store.multipartPut(ctx, key, r, &buf)
+}
+
+//multipartPut upload an object by multipart upload, buf holds data of the first part
+func (store *COSStore) multipartPut(ctx context.Context, key string, r io.Reader, buf *bytes.Buffer) error {
+	entry := log.WithFields(log.Fields{Function: "multipartPut"})
+	res, _, err := store.cosCli.Object.InitiateMultipartUpload(ctx, key, nil)
+	if err != nil {
+		entry.WithError(err).Errorf("initiating multipart upload of %s", key)
+		return err
+	}
+	opt := &cos.CompleteMultipartUploadOptions{}
+	for partNumber := 1; buf.Len() > 0; partNumber++ {
+		resp, err := store.cosCli.Object.UploadPart(ctx, key, res.UploadID, partNumber, bytes.NewReader(buf.Bytes()), nil)
+		if err != nil {
+			entry.WithError(err).Errorf("uploading part %d of %s", partNumber, key)
+			store.cosCli.Object.AbortMultipartUpload(ctx, key, res.UploadID)
+			return err
+		}
+		opt.Parts = append(opt.Parts, cos.Object{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		buf.Reset()
+		if _, err := buf.ReadFrom(io.LimitReader(r, cosPartSize)); err != nil {
+			store.cosCli.Object.AbortMultipartUpload(ctx, key, res.UploadID)
+			return err
+		}
+	}
+	_, _, err = store.cosCli.Object.CompleteMultipartUpload(ctx, key, res.UploadID, opt)
+	if err != nil {
+		entry.WithError(err).Errorf("completing multipart upload of %s", key)
+		store.cosCli.Object.AbortMultipartUpload(ctx, key, res.UploadID)
+		return err
+	}
+	return nil
+}
+
+//Get download an object
+func (store *COSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := store.cosCli.Object.Get(ctx, key, nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+//PutTags replace all tags of an object
+func (store *COSStore) PutTags(ctx context.Context, key string, tags map[string]string) error {
+	opt := &cos.ObjectPutTaggingOptions{TagSet: make([]cos.ObjectTaggingTag, 0, len(tags))}
+	for k, v := range tags {
+		opt.TagSet = append(opt.TagSet, cos.ObjectTaggingTag{Key: k, Value: v})
+	}
+	sort.Slice(opt.TagSet, func(i, j int) bool { return opt.TagSet[i].Key < opt.TagSet[j].Key })
+	_, err := store.cosCli.Object.PutTagging(ctx, key, opt)
+	return err
+}
+
+//GetTags get tags of an object
+func (store *COSStore) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	res, _, err := store.cosCli.Object.GetTagging(ctx, key)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, tag := range res.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+//List list keys of all objects starting with prefix
+func (store *COSStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	opt := &cos.BucketGetOptions{Prefix: prefix, MaxKeys: 1000}
+	for {
+		res, _, err := store.cosCli.Bucket.Get(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range res.Contents {
+			keys = append(keys, obj.Key)
+		}
+		if !res.IsTruncated {
+			return keys, nil
+		}
+		opt.Marker = res.NextMarker
+	}
+}
+
+//Delete delete an object
+func (store *COSStore) Delete(ctx context.Context, key string) error {
+	_, err := store.cosCli.Object.Delete(ctx, key)
+	return err
+}
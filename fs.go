@@ -0,0 +1,301 @@
+package ytcompare
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tylerb/graceful"
+)
+
+//FSStore object store of local filesystem, object with key <minerID>_<fileFrom> is saved as <root>/<minerID>/<minerID>_<fileFrom>,
+//and tags of the object are saved in sidecar file <root>/<minerID>/.<minerID>_<fileFrom>.tags
+type FSStore struct {
+	rootDir string
+	server  *graceful.Server
+}
+
+var _ ObjectStore = (*FSStore)(nil)
+
+//NewFSStore create a new FSStore instance
+func NewFSStore(config *FSConfig) (*FSStore, error) {
+	entry := log.WithFields(log.Fields{Function: "NewFSStore"})
+	rootDir, err := filepath.Abs(config.RootDir)
+	if err != nil {
+		entry.WithError(err).Errorf("get absolute path failed: %s", config.RootDir)
+		return nil, err
+	}
+	err = os.MkdirAll(rootDir, 0755)
+	if err != nil {
+		entry.WithError(err).Errorf("creating root directory failed: %s", rootDir)
+		return nil, err
+	}
+	return &FSStore{rootDir: rootDir}, nil
+}
+
+//objectPath get file path of object
+func (store *FSStore) objectPath(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasPrefix(filepath.Base(key), ".") {
+		return "", fmt.Errorf("invalid object key: %s", key)
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return "", fmt.Errorf("invalid object key: %s", key)
+		}
+	}
+	if strings.Contains(key, "/") {
+		return filepath.Join(store.rootDir, filepath.FromSlash(key)), nil
+	}
+	if idx := strings.Index(key, "_"); idx > 0 {
+		return filepath.Join(store.rootDir, key[:idx], key), nil
+	}
+	return filepath.Join(store.rootDir, key), nil
+}
+
+//objectKey get key of object by file path, returns empty string if path is not an object
+func (store *FSStore) objectKey(path string) string {
+	rel, err := filepath.Rel(store.rootDir, path)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return ""
+	}
+	if idx := strings.Index(rel, "/"); idx > 0 && strings.Count(rel, "/") == 1 && strings.HasPrefix(name, rel[:idx]+"_") {
+		return name
+	}
+	return rel
+}
+
+func tagsPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tags", filepath.Base(path)))
+}
+
+//writeFile write content of r to file atomically
+func writeFile(path string, r io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), fmt.Sprintf(".%s.tmp", filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//Put upload an object
+func (store *FSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := store.objectPath(key)
+	if err != nil {
+		return err
+	}
+	err = writeFile(path, r)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(tagsPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//Get download an object
+func (store *FSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := store.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+//PutTags replace all tags of an object
+func (store *FSStore) PutTags(ctx context.Context, key string, tags map[string]string) error {
+	path, err := store.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotFound
+		}
+		return err
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return writeFile(tagsPath(path), strings.NewReader(string(data)))
+}
+
+//GetTags get tags of an object
+func (store *FSStore) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	path, err := store.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	tags := make(map[string]string)
+	data, err := ioutil.ReadFile(tagsPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tags, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &tags)
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+//List list keys of all objects starting with prefix
+func (store *FSStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := filepath.Walk(store.rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		key := store.objectKey(path)
+		if key != "" && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+//Delete delete an object
+func (store *FSStore) Delete(ctx context.Context, key string) error {
+	path, err := store.objectPath(key)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{path, tagsPath(path)} {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+//ServeHTTP serve objects in the same way as COS: GET /<key> for downloading object and GET /<key>?tagging for fetching tags
+func (store *FSStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if _, ok := r.URL.Query()["tagging"]; ok {
+		tags, err := store.GetTags(r.Context(), key)
+		if err != nil {
+			if err == ErrObjectNotFound {
+				http.NotFound(w, r)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(newObjectTagging(tags))
+		return
+	}
+	path, err := store.objectPath(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, key, info.ModTime(), file)
+}
+
+//Serve start HTTP server for downloading objects in background
+func (store *FSStore) Serve(bindAddr string) error {
+	entry := log.WithFields(log.Fields{Function: "Serve"})
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		entry.WithError(err).Errorf("listening on address failed: %s", bindAddr)
+		return err
+	}
+	store.server = &graceful.Server{
+		Server:           &http.Server{Addr: bindAddr, Handler: store},
+		NoSignalHandling: true,
+	}
+	go func() {
+		err := store.server.Serve(listener)
+		if err != nil {
+			entry.WithError(err).Error("HTTP server of filesystem store stopped")
+		}
+	}()
+	entry.Infof("HTTP server of filesystem store listening on %s", bindAddr)
+	return nil
+}
+
+//Stop stop HTTP server of the store, waiting at most timeout for active connections to finish
+func (store *FSStore) Stop(timeout time.Duration) {
+	if store.server != nil {
+		store.server.Stop(timeout)
+		<-store.server.StopChan()
+	}
+}
@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/lestrrat-go/file-rotatelogs v2.3.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.3 // indirect
+	github.com/minio/minio-go/v6 v6.0.57
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/cobra v1.0.0
@@ -12,6 +13,8 @@ require (
 	github.com/tebeka/strftime v0.1.5 // indirect
 	github.com/tencentyun/cos-go-sdk-v5 v0.7.10
 	github.com/tylerb/graceful v1.2.15
+	go.etcd.io/bbolt v1.3.5
 	go.mongodb.org/mongo-driver v1.3.3
+	golang.org/x/crypto v0.0.0-20190530122614-20be4c3c3ed5
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 )
@@ -0,0 +1,25 @@
+package ytcompare
+
+import (
+	"sort"
+	"time"
+)
+
+//Journal pending commit of one window, cursors of all miners and checkpoint are applied together after the journal is written,
+//so that a crash in between is recovered by applying the journal again on restart
+type Journal struct {
+	ID         int32       `bson:"_id"`
+	CheckPoint *CheckPoint `bson:"checkpoint"`
+	Cursors    []*Cursor   `bson:"cursors"`
+	Timestamp  int64       `bson:"timestamp"`
+}
+
+//newJournal create journal of window with new cursors of all miners which received compare files in the window
+func newJournal(checkPoint *CheckPoint, cursors map[int32]*Cursor) *Journal {
+	journal := &Journal{ID: 1, CheckPoint: checkPoint, Cursors: make([]*Cursor, 0, len(cursors)), Timestamp: time.Now().Unix()}
+	for _, cursor := range cursors {
+		journal.Cursors = append(journal.Cursors, cursor)
+	}
+	sort.Slice(journal.Cursors, func(i, j int) bool { return journal.Cursors[i].ID < journal.Cursors[j].ID })
+	return journal
+}
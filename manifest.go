@@ -0,0 +1,120 @@
+package ytcompare
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+//ManifestVersion current version of manifest
+const ManifestVersion = 1
+
+//Manifest manifest of all compare files of one miner, saved as object manifest/<minerID>
+type Manifest struct {
+	Version   int             `json:"version"`
+	MinerID   int32           `json:"minerId"`
+	Timestamp int64           `json:"timestamp"`
+	Files     []*ManifestFile `json:"files"`
+}
+
+//ManifestFile one compare file in manifest
+type ManifestFile struct {
+	Key       string `json:"key"`
+	FileFrom  int64  `json:"fileFrom"`
+	Start     int64  `json:"start"`
+	Range     int64  `json:"range"`
+	Size      int64  `json:"size"`
+	Checksum  string `json:"sha256"`
+	Signature string `json:"signature,omitempty"`
+}
+
+//manifestKey key of manifest object of miner
+func manifestKey(nodeID int32) string {
+	return fmt.Sprintf("manifest/%d", nodeID)
+}
+
+//digestWriter calculate size and SHA-256 of data written
+type digestWriter struct {
+	hash hash.Hash
+	size int64
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	return w.hash.Write(p)
+}
+
+//GetManifest get manifest of miner, returns an empty manifest if not exists
+func (compare *Compare) GetManifest(ctx context.Context, nodeID int32) (*Manifest, error) {
+	entry := log.WithFields(log.Fields{Function: "GetManifest", MinerID: nodeID})
+	manifest := &Manifest{Version: ManifestVersion, MinerID: nodeID, Files: make([]*ManifestFile, 0)}
+	reader, err := compare.Storage.Get(ctx, manifestKey(nodeID))
+	if err != nil {
+		if err == ErrObjectNotFound {
+			return manifest, nil
+		}
+		entry.WithError(err).Error("fetch manifest")
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		entry.WithError(err).Error("reading manifest")
+		return nil, err
+	}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		entry.WithError(err).Error("decoding manifest")
+		return nil, err
+	}
+	return manifest, nil
+}
+
+//updateManifest add file to manifest of miner, the file replaces the last one if they have the same key,
+//only the latest manifestMaxFiles files are kept if manifestMaxFiles is greater than 0
+func (compare *Compare) updateManifest(ctx context.Context, nodeID int32, file *ManifestFile) error {
+	entry := log.WithFields(log.Fields{Function: "updateManifest", MinerID: nodeID})
+	manifest, err := compare.GetManifest(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if n := len(manifest.Files); n > 0 && manifest.Files[n-1].Key == file.Key {
+		manifest.Files[n-1] = file
+	} else {
+		manifest.Files = append(manifest.Files, file)
+	}
+	if compare.manifestMaxFiles > 0 && len(manifest.Files) > compare.manifestMaxFiles {
+		manifest.Files = manifest.Files[len(manifest.Files)-compare.manifestMaxFiles:]
+	}
+	manifest.Version = ManifestVersion
+	manifest.Timestamp = time.Now().Unix()
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		entry.WithError(err).Error("encoding manifest")
+		return err
+	}
+	err = compare.Storage.Put(ctx, manifestKey(nodeID), bytes.NewReader(data))
+	if err != nil {
+		entry.WithError(err).Error("uploading manifest")
+		return err
+	}
+	entry.Debugf("manifest updated: %s", file.Key)
+	return nil
+}
+
+//newManifestFile create manifest entry of uploaded compare file
+func newManifestFile(key string, cursor *Cursor, digest *digestWriter, signed bool) *ManifestFile {
+	file := &ManifestFile{Key: key, FileFrom: cursor.FileFrom, Start: cursor.From, Range: cursor.Range, Size: digest.size, Checksum: hex.EncodeToString(digest.hash.Sum(nil))}
+	if signed {
+		file.Signature = key + cmpfile.SignatureSuffix
+	}
+	return file
+}
@@ -0,0 +1,101 @@
+package ytcompare
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//MemObjectStore object store keeping all objects in memory, mainly for testing
+type MemObjectStore struct {
+	objects map[string][]byte
+	tags    map[string]map[string]string
+	lock    sync.RWMutex
+}
+
+var _ ObjectStore = (*MemObjectStore)(nil)
+
+//NewMemObjectStore create a new MemObjectStore instance
+func NewMemObjectStore() *MemObjectStore {
+	return &MemObjectStore{objects: make(map[string][]byte), tags: make(map[string]map[string]string)}
+}
+
+//Put upload an object
+func (store *MemObjectStore) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.objects[key] = data
+	delete(store.tags, key)
+	return nil
+}
+
+//Get download an object
+func (store *MemObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	data, ok := store.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+//PutTags replace all tags of an object
+func (store *MemObjectStore) PutTags(ctx context.Context, key string, tags map[string]string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.objects[key]; !ok {
+		return ErrObjectNotFound
+	}
+	m := make(map[string]string, len(tags))
+	for k, v := range tags {
+		m[k] = v
+	}
+	store.tags[key] = m
+	return nil
+}
+
+//GetTags get tags of an object
+func (store *MemObjectStore) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if _, ok := store.objects[key]; !ok {
+		return nil, ErrObjectNotFound
+	}
+	m := make(map[string]string, len(store.tags[key]))
+	for k, v := range store.tags[key] {
+		m[k] = v
+	}
+	return m, nil
+}
+
+//List list keys of all objects starting with prefix
+func (store *MemObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	keys := make([]string, 0)
+	for k := range store.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+//Delete delete an object
+func (store *MemObjectStore) Delete(ctx context.Context, key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.objects, key)
+	delete(store.tags, key)
+	return nil
+}
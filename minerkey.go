@@ -0,0 +1,39 @@
+package ytcompare
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+//ErrNoMinerKey public key of miner not found in key collection
+var ErrNoMinerKey = errors.New("no public key of miner")
+
+//minerKey get X25519 public key of miner from state store, returns nil if compare files are not encrypted,
+//or miner has no public key and uploading plaintext is allowed
+func (compare *Compare) minerKey(ctx context.Context, nodeID int32) ([]byte, error) {
+	entry := log.WithFields(log.Fields{Function: "minerKey", MinerID: nodeID})
+	if !compare.encrypt {
+		return nil, nil
+	}
+	publicKey, err := compare.state.GetMinerKey(ctx, nodeID)
+	if err != nil {
+		if err == ErrStateNotFound {
+			if compare.allowPlaintext {
+				entry.Warn("no public key of miner, uploading compare data without encryption")
+				return nil, nil
+			}
+			return nil, ErrNoMinerKey
+		}
+		entry.WithError(err).Error("fetch public key of miner")
+		return nil, err
+	}
+	key, err := cmpfile.ParseKey(publicKey)
+	if err != nil {
+		entry.WithError(err).Errorf("parsing public key of miner: %s", publicKey)
+		return nil, err
+	}
+	return key, nil
+}
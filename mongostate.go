@@ -0,0 +1,153 @@
+package ytcompare
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//MongoStateStore state store of MongoDB, cursors and checkpoint of a window are committed through a journal document,
+//so that a crash during committing is recovered by applying the journal again
+type MongoStateStore struct {
+	dbCli         *mongo.Client
+	dbName        string
+	keyCollection string
+}
+
+var _ StateStore = (*MongoStateStore)(nil)
+
+//NewMongoStateStore create a new MongoStateStore instance
+func NewMongoStateStore(ctx context.Context, url, dbName, keyCollection string) (*MongoStateStore, error) {
+	entry := log.WithFields(log.Fields{Function: "NewMongoStateStore"})
+	dbClient, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		entry.WithError(err).Errorf("creating mongo DB client failed: %s", url)
+		return nil, err
+	}
+	return &MongoStateStore{dbCli: dbClient, dbName: dbName, keyCollection: keyCollection}, nil
+}
+
+//GetCheckPoint get checkpoint of the last committed window
+func (store *MongoStateStore) GetCheckPoint(ctx context.Context) (*CheckPoint, error) {
+	checkPoint := new(CheckPoint)
+	err := store.dbCli.Database(store.dbName).Collection(CheckPointTab).FindOne(ctx, bson.M{"_id": 1}).Decode(checkPoint)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+	return checkPoint, nil
+}
+
+//GetCursor get cursor of miner
+func (store *MongoStateStore) GetCursor(ctx context.Context, nodeID int32) (*Cursor, error) {
+	cursor := new(Cursor)
+	err := store.dbCli.Database(store.dbName).Collection(CursorTab).FindOne(ctx, bson.M{"_id": nodeID}).Decode(cursor)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+	return cursor, nil
+}
+
+//Commit write journal of window and apply it, cursors and checkpoint are not changed if writing journal failed,
+//the journal is left in database if applying failed and will be applied again by Recover
+func (store *MongoStateStore) Commit(ctx context.Context, journal *Journal) error {
+	entry := log.WithFields(log.Fields{Function: "Commit"})
+	journalTab := store.dbCli.Database(store.dbName).Collection(JournalTab)
+	_, err := journalTab.ReplaceOne(ctx, bson.M{"_id": journal.ID}, journal, options.Replace().SetUpsert(true))
+	if err != nil {
+		entry.WithError(err).Errorf("writing journal of window %d", journal.CheckPoint.Start)
+		return err
+	}
+	entry.Debugf("journal of window %d written with %d cursors", journal.CheckPoint.Start, len(journal.Cursors))
+	return store.apply(ctx, journal)
+}
+
+//Recover apply journal left by the last commit if exists
+func (store *MongoStateStore) Recover(ctx context.Context) error {
+	entry := log.WithFields(log.Fields{Function: "Recover"})
+	journalTab := store.dbCli.Database(store.dbName).Collection(JournalTab)
+	journal := new(Journal)
+	err := journalTab.FindOne(ctx, bson.M{"_id": 1}).Decode(journal)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		entry.WithError(err).Error("fetch journal record")
+		return err
+	}
+	entry.Infof("recovering window %d from journal with %d cursors", journal.CheckPoint.Start, len(journal.Cursors))
+	return store.apply(ctx, journal)
+}
+
+//apply write cursors and checkpoint of journal and remove the journal, all writes are idempotent so that applying can be repeated
+func (store *MongoStateStore) apply(ctx context.Context, journal *Journal) error {
+	entry := log.WithFields(log.Fields{Function: "apply"})
+	db := store.dbCli.Database(store.dbName)
+	cursorTab := db.Collection(CursorTab)
+	for _, cursor := range journal.Cursors {
+		_, err := cursorTab.UpdateOne(ctx, bson.M{"_id": cursor.ID}, bson.M{"$set": bson.M{"from": cursor.From, "range": cursor.Range, "fileFrom": cursor.FileFrom, "timestamp": cursor.Timestamp}}, options.Update().SetUpsert(true))
+		if err != nil {
+			entry.WithError(err).Errorf("update cursor record: %+v", cursor)
+			return err
+		}
+	}
+	checkPoint := journal.CheckPoint
+	_, err := db.Collection(CheckPointTab).UpdateOne(ctx, bson.M{"_id": checkPoint.ID}, bson.M{"$set": bson.M{"start": checkPoint.Start, "range": checkPoint.Range, "shards": checkPoint.Shards, "timestamp": checkPoint.Timestamp}}, options.Update().SetUpsert(true))
+	if err != nil {
+		entry.WithError(err).Errorf("update checkpoint record: %+v", checkPoint)
+		return err
+	}
+	_, err = db.Collection(JournalTab).DeleteOne(ctx, bson.M{"_id": journal.ID})
+	if err != nil {
+		entry.WithError(err).Errorf("remove journal of window %d", checkPoint.Start)
+		return err
+	}
+	entry.Debugf("window %d committed: %+v", checkPoint.Start, checkPoint)
+	return nil
+}
+
+//SaveConflicts save conflicts of one window
+func (store *MongoStateStore) SaveConflicts(ctx context.Context, conflicts []*Conflict) error {
+	entry := log.WithFields(log.Fields{Function: "SaveConflicts"})
+	conflictTab := store.dbCli.Database(store.dbName).Collection(ConflictTab)
+	for _, conflict := range conflicts {
+		_, err := conflictTab.UpdateOne(ctx, bson.M{"shardId": conflict.ShardID, "start": conflict.Start}, bson.M{"$set": bson.M{"range": conflict.Range, "timestamp": conflict.Timestamp}, "$addToSet": bson.M{"assignments": bson.M{"$each": conflict.Assignments}}}, options.Update().SetUpsert(true))
+		if err != nil {
+			entry.WithError(err).WithField(ShardID, conflict.ShardID).Errorf("saving conflict record: %d", conflict.ShardID)
+			return err
+		}
+	}
+	return nil
+}
+
+//GetMinerKey get base64 encoded public key of miner
+func (store *MongoStateStore) GetMinerKey(ctx context.Context, nodeID int32) (string, error) {
+	minerKey := new(MinerKey)
+	err := store.dbCli.Database(store.dbName).Collection(store.keyCollection).FindOne(ctx, bson.M{"_id": nodeID}).Decode(minerKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", ErrStateNotFound
+		}
+		return "", err
+	}
+	return minerKey.PublicKey, nil
+}
+
+//PutMinerKey set base64 encoded public key of miner
+func (store *MongoStateStore) PutMinerKey(ctx context.Context, nodeID int32, publicKey string) error {
+	_, err := store.dbCli.Database(store.dbName).Collection(store.keyCollection).UpdateOne(ctx, bson.M{"_id": nodeID}, bson.M{"$set": bson.M{"publicKey": publicKey}}, options.Update().SetUpsert(true))
+	return err
+}
+
+//Close disconnect from MongoDB
+func (store *MongoStateStore) Close(ctx context.Context) error {
+	return store.dbCli.Disconnect(ctx)
+}
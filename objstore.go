@@ -0,0 +1,83 @@
+package ytcompare
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	//StorageCOS storage type of tencent COS
+	StorageCOS = "cos"
+	//StorageS3 storage type of S3-compatible services
+	StorageS3 = "s3"
+	//StorageFS storage type of local filesystem
+	StorageFS = "fs"
+)
+
+//ErrObjectNotFound object not found in object store
+var ErrObjectNotFound = errors.New("object not found")
+
+//ObjectStore storage of compare files
+type ObjectStore interface {
+	//Put upload an object, the object will be overwritten if exists
+	Put(ctx context.Context, key string, r io.Reader) error
+	//Get download an object, ErrObjectNotFound is returned if not exists
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	//PutTags replace all tags of an object
+	PutTags(ctx context.Context, key string, tags map[string]string) error
+	//GetTags get tags of an object, ErrObjectNotFound is returned if not exists
+	GetTags(ctx context.Context, key string) (map[string]string, error)
+	//List list keys of all objects starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	//Delete delete an object
+	Delete(ctx context.Context, key string) error
+}
+
+//objectTag tag of object
+type objectTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+//objectTagging XML body of object tagging, compatible with COS and S3
+type objectTagging struct {
+	XMLName xml.Name    `xml:"Tagging"`
+	TagSet  []objectTag `xml:"TagSet>Tag"`
+}
+
+func newObjectTagging(tags map[string]string) *objectTagging {
+	tagging := &objectTagging{TagSet: make([]objectTag, 0, len(tags))}
+	for k, v := range tags {
+		tagging.TagSet = append(tagging.TagSet, objectTag{Key: k, Value: v})
+	}
+	sort.Slice(tagging.TagSet, func(i, j int) bool { return tagging.TagSet[i].Key < tagging.TagSet[j].Key })
+	return tagging
+}
+
+//Tags convert tag set to map
+func (tagging *objectTagging) Tags() map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range tagging.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
+
+//NewObjectStore create object store by storage type in configuration
+func NewObjectStore(config *Config) (ObjectStore, error) {
+	switch strings.ToLower(config.StorageType) {
+	case StorageCOS, "":
+		return NewCOSStore(config.COS)
+	case StorageS3:
+		return NewS3Store(config.S3)
+	case StorageFS:
+		return NewFSStore(config.FS)
+	default:
+		return nil, fmt.Errorf("no such storage type: %s", config.StorageType)
+	}
+}
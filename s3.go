@@ -0,0 +1,111 @@
+package ytcompare
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+//s3PartSize part size of multipart upload
+const s3PartSize = 16 * 1024 * 1024
+
+//S3Store object store of S3-compatible services, such as MinIO, AWS S3 and Ceph RGW
+type S3Store struct {
+	s3Cli      *minio.Client
+	bucketName string
+}
+
+var _ ObjectStore = (*S3Store)(nil)
+
+//NewS3Store create a new S3Store instance
+func NewS3Store(config *S3Config) (*S3Store, error) {
+	entry := log.WithFields(log.Fields{Function: "NewS3Store"})
+	s3Client, err := minio.NewWithRegion(config.Endpoint, config.AccessKey, config.SecretKey, config.UseSSL, config.Region)
+	if err != nil {
+		entry.WithError(err).Errorf("creating S3 client failed: %s", config.Endpoint)
+		return nil, err
+	}
+	return &S3Store{s3Cli: s3Client, bucketName: config.BucketName}, nil
+}
+
+//Put upload an object, multipart upload is used if size of object exceeds one part
+func (store *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, s3PartSize))
+	if err != nil {
+		return err
+	}
+	if n < s3PartSize {
+		_, err := store.s3Cli.PutObjectWithContext(ctx, store.bucketName, key, bytes.NewReader(buf.Bytes()), n, minio.PutObjectOptions{})
+		return err
+	}
+	_, err = store.s3Cli.PutObjectWithContext(ctx, store.bucketName, key, io.MultiReader(&buf, r), -1, minio.PutObjectOptions{PartSize: s3PartSize})
+	return err
+}
+
+//Get download an object
+func (store *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := store.s3Cli.GetObjectWithContext(ctx, store.bucketName, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, convertS3Error(err)
+	}
+	return obj, nil
+}
+
+//PutTags replace all tags of an object
+func (store *S3Store) PutTags(ctx context.Context, key string, tags map[string]string) error {
+	return convertS3Error(store.s3Cli.PutObjectTaggingWithContext(ctx, store.bucketName, key, tags))
+}
+
+//GetTags get tags of an object
+func (store *S3Store) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	body, err := store.s3Cli.GetObjectTaggingWithContext(ctx, store.bucketName, key)
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	tagging := new(objectTagging)
+	if err := xml.Unmarshal([]byte(body), tagging); err != nil {
+		return nil, err
+	}
+	return tagging.Tags(), nil
+}
+
+//List list keys of all objects starting with prefix
+func (store *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	keys := make([]string, 0)
+	for obj := range store.s3Cli.ListObjectsV2(store.bucketName, prefix, true, doneCh) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+//Delete delete an object
+func (store *S3Store) Delete(ctx context.Context, key string) error {
+	return convertS3Error(store.s3Cli.RemoveObject(store.bucketName, key))
+}
+
+func convertS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	resp := minio.ToErrorResponse(err)
+	if resp.StatusCode == http.StatusNotFound || strings.HasPrefix(resp.Code, "NoSuch") {
+		return ErrObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package ytcompare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//ShardSource source of shards for comparing
+type ShardSource interface {
+	//SNCount number of SNs provided by the source
+	SNCount() int
+	//FetchShards fetch shards stored in SN snID in the time window [from, to), each shard is passed to handler once fetched
+	FetchShards(ctx context.Context, snID int32, from int64, to int64, handler func(shard *Shard) error) error
+}
+
+//errPagingIgnored sync service returned shards not matching paging parameters
+var errPagingIgnored = errors.New("paging parameters ignored by sync service")
+
+//subRangeGrowAfter sub-range of SN is doubled after this count of successful requests in a row
+const subRangeGrowAfter = 16
+
+//statusError sync service responded with unexpected HTTP status code
+type statusError struct {
+	code int
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", err.code)
+}
+
+//isOversize whether err is likely caused by too many shards in one response, such as timeout, response truncated,
+//or sync service reporting that the request is too large or takes too long, errors like connection refused are not included
+func isOversize(err error) bool {
+	if err == io.ErrUnexpectedEOF || err == context.DeadlineExceeded {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if statusErr, ok := err.(*statusError); ok {
+		return statusErr.code == http.StatusRequestEntityTooLarge || statusErr.code == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+const (
+	pagingUnknown = iota
+	pagingSupported
+	pagingUnsupported
+)
+
+//snState fetching state of one SN
+type snState struct {
+	lock sync.Mutex
+	//paging whether sync service of SN supports paging
+	paging int
+	//subRange max time range of one request, 0 means no limit
+	subRange int64
+	//successes count of successful requests in a row since subRange is changed
+	successes int
+}
+
+//HTTPShardSource fetch shards from sync services of SNs by HTTP
+type HTTPShardSource struct {
+	httpCli  *http.Client
+	urls     []string
+	pageSize int
+	states   []*snState
+}
+
+//NewHTTPShardSource create a new HTTPShardSource instance, shards are fetched page by page if pageSize is greater than 0,
+//otherwise all SNs are treated as not supporting paging so that windows failed because of too many shards can be split
+func NewHTTPShardSource(httpCli *http.Client, urls []string, pageSize int) *HTTPShardSource {
+	states := make([]*snState, len(urls))
+	for i := range states {
+		states[i] = new(snState)
+		if pageSize <= 0 {
+			states[i].paging = pagingUnsupported
+		}
+	}
+	return &HTTPShardSource{httpCli: httpCli, urls: urls, pageSize: pageSize, states: states}
+}
+
+//SNCount number of SNs provided by the source
+func (source *HTTPShardSource) SNCount() int {
+	return len(source.urls)
+}
+
+//FetchShards fetch shards from sync service of SN snID by calling /sync/GetStoredShards,
+//if the sync service does not support paging and the request failed because of too many shards, the window will be split into smaller sub-windows,
+//sub-windows are enlarged again after several successful requests
+func (source *HTTPShardSource) FetchShards(ctx context.Context, snID int32, from int64, to int64, handler func(shard *Shard) error) error {
+	entry := log.WithFields(log.Fields{Function: "FetchShards", SNID: snID})
+	state := source.states[snID]
+	start := from
+	for start < to {
+		state.lock.Lock()
+		end := to
+		if state.subRange > 0 && start+state.subRange < to {
+			end = start + state.subRange
+		}
+		state.lock.Unlock()
+		delivered, err := source.fetchWindow(ctx, snID, start, end, handler)
+		if err != nil {
+			if isOversize(err) && ctx.Err() == nil {
+				source.probePaging(ctx, snID, start, end)
+			}
+			state.lock.Lock()
+			splittable := state.paging == pagingUnsupported && end-start > 1 && ctx.Err() == nil && isOversize(err)
+			if splittable {
+				state.subRange = (end - start + 1) / 2
+				state.successes = 0
+			}
+			state.lock.Unlock()
+			if splittable && delivered == 0 {
+				entry.WithError(err).Warnf("fetching shards from %d to %d failed, split into sub-windows of %d seconds", start, end, (end-start+1)/2)
+				continue
+			}
+			return err
+		}
+		state.lock.Lock()
+		if state.subRange > 0 {
+			state.successes++
+			if state.successes >= subRangeGrowAfter {
+				state.subRange *= 2
+				state.successes = 0
+				if state.subRange >= to-from {
+					state.subRange = 0
+				}
+				entry.Debugf("sub-window of fetching enlarged to %d seconds", state.subRange)
+			}
+		}
+		state.lock.Unlock()
+		start = end
+	}
+	return nil
+}
+
+//probePaging find out whether sync service of SN supports paging by requesting one shard in window [from, to) if it is still unknown,
+//sync service returning more than one shard or failing with the same kind of error as the whole window does not support paging
+func (source *HTTPShardSource) probePaging(ctx context.Context, snID int32, from int64, to int64) {
+	entry := log.WithFields(log.Fields{Function: "probePaging", SNID: snID})
+	state := source.states[snID]
+	state.lock.Lock()
+	paging := state.paging
+	state.lock.Unlock()
+	if paging != pagingUnknown || source.pageSize <= 0 {
+		return
+	}
+	var count int64
+	_, err := GetCompareShards(ctx, source.httpCli, source.urls[snID], from, to, 0, 1, func(shard *Shard) error {
+		count++
+		if count > 1 {
+			return errPagingIgnored
+		}
+		return nil
+	})
+	switch {
+	case err == errPagingIgnored || (err != nil && isOversize(err)):
+		paging = pagingUnsupported
+		entry.Warnf("sync service does not support paging: %s", source.urls[snID])
+	case err == nil && count == 1:
+		paging = pagingSupported
+	default:
+		return
+	}
+	state.lock.Lock()
+	state.paging = paging
+	state.lock.Unlock()
+}
+
+//fetchWindow fetch shards in window [from, to) page by page, returns count of shards passed to handler
+func (source *HTTPShardSource) fetchWindow(ctx context.Context, snID int32, from int64, to int64, handler func(shard *Shard) error) (int64, error) {
+	entry := log.WithFields(log.Fields{Function: "fetchWindow", SNID: snID})
+	state := source.states[snID]
+	state.lock.Lock()
+	limit := source.pageSize
+	if state.paging == pagingUnsupported {
+		limit = 0
+	}
+	state.lock.Unlock()
+	var delivered int64
+	var lastID int64
+	for {
+		cursor := lastID
+		count, err := GetCompareShards(ctx, source.httpCli, source.urls[snID], from, to, cursor, limit, func(shard *Shard) error {
+			if cursor > 0 && shard.ID <= cursor {
+				return errPagingIgnored
+			}
+			delivered++
+			if shard.ID > lastID {
+				lastID = shard.ID
+			}
+			return handler(shard)
+		})
+		if err == errPagingIgnored || (err == nil && limit > 0 && count > int64(limit)) {
+			//sync service ignored paging parameters and all shards in window have been returned by the first request
+			state.lock.Lock()
+			if state.paging != pagingUnsupported {
+				entry.Warnf("sync service does not support paging: %s", source.urls[snID])
+			}
+			state.paging = pagingUnsupported
+			state.lock.Unlock()
+			return delivered, nil
+		}
+		if err != nil {
+			if limit > 0 && delivered > int64(limit) {
+				//more shards than one page are returned before failure, so sync service does not support paging
+				state.lock.Lock()
+				state.paging = pagingUnsupported
+				state.lock.Unlock()
+			}
+			return delivered, err
+		}
+		if limit <= 0 || count < int64(limit) {
+			return delivered, nil
+		}
+		state.lock.Lock()
+		state.paging = pagingSupported
+		state.lock.Unlock()
+	}
+}
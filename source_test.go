@@ -0,0 +1,142 @@
+package ytcompare
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+//syncServer fake sync service holding one shard per second in [0, shardCount), requests returning more than maxShards shards fail with 413
+type syncServer struct {
+	paging     bool
+	shardCount int64
+	maxShards  int
+
+	lock     sync.Mutex
+	requests []string
+}
+
+func (server *syncServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	server.requests = append(server.requests, r.URL.RawQuery)
+	maxShards := server.maxShards
+	server.lock.Unlock()
+	query := r.URL.Query()
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+	lastID, _ := strconv.ParseInt(query.Get("lastId"), 10, 64)
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	shards := make([]*Shard, 0)
+	for t := from; t < to && t < server.shardCount; t++ {
+		shard := &Shard{ID: 1000 + t, NodeID: int32(t % 3), VHF: []byte{byte(t), 1, 2, 3}, BlockID: t}
+		if server.paging && shard.ID <= lastID {
+			continue
+		}
+		shards = append(shards, shard)
+		if server.paging && limit > 0 && len(shards) == limit {
+			break
+		}
+	}
+	if len(shards) > maxShards {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	json.NewEncoder(w).Encode(shards)
+}
+
+//fetchAll fetch shards of window [from, to) from SN 0 twice, checks that all shards are fetched in order without duplicates
+func fetchAll(t *testing.T, source *HTTPShardSource, from, to int64) {
+	t.Helper()
+	for i := 0; i < 2; i++ {
+		var ids []int64
+		err := source.FetchShards(context.Background(), 0, from, to, func(shard *Shard) error {
+			ids = append(ids, shard.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("fetching shards from %d to %d: %s", from, to, err)
+		}
+		if int64(len(ids)) != to-from {
+			t.Fatalf("expect %d shards but got %d", to-from, len(ids))
+		}
+		for j, id := range ids {
+			if id != 1000+from+int64(j) {
+				t.Fatalf("shard %d: expect ID %d but got %d", j, 1000+from+int64(j), id)
+			}
+		}
+	}
+}
+
+func TestFetchShardsPagingSupported(t *testing.T) {
+	server := &syncServer{paging: true, shardCount: 100, maxShards: 10}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	source := NewHTTPShardSource(ts.Client(), []string{ts.URL}, 7)
+	fetchAll(t, source, 0, 100)
+	state := source.states[0]
+	if state.paging != pagingSupported || state.subRange != 0 {
+		t.Fatalf("expect paging supported without sub-range, got paging %d, sub-range %d", state.paging, state.subRange)
+	}
+	for _, query := range server.requests {
+		values, _ := url.ParseQuery(query)
+		if values.Get("from") != "0" || values.Get("to") != "100" {
+			t.Fatalf("window split although paging is supported: %s", query)
+		}
+	}
+}
+
+func TestFetchShardsPagingIgnored(t *testing.T) {
+	server := &syncServer{paging: false, shardCount: 100, maxShards: 10}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	source := NewHTTPShardSource(ts.Client(), []string{ts.URL}, 7)
+	fetchAll(t, source, 0, 100)
+	state := source.states[0]
+	if state.paging != pagingUnsupported || state.subRange == 0 || state.subRange > 10 {
+		t.Fatalf("expect paging unsupported with sub-range not greater than 10, got paging %d, sub-range %d", state.paging, state.subRange)
+	}
+}
+
+func TestFetchShardsPagingDisabled(t *testing.T) {
+	server := &syncServer{paging: true, shardCount: 100, maxShards: 10}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	source := NewHTTPShardSource(ts.Client(), []string{ts.URL}, 0)
+	fetchAll(t, source, 0, 100)
+	state := source.states[0]
+	if state.paging != pagingUnsupported || state.subRange == 0 || state.subRange > 10 {
+		t.Fatalf("expect paging unsupported with sub-range not greater than 10, got paging %d, sub-range %d", state.paging, state.subRange)
+	}
+	for _, query := range server.requests {
+		values, _ := url.ParseQuery(query)
+		if values.Get("limit") != "" {
+			t.Fatalf("paging parameters sent although paging is disabled: %s", query)
+		}
+	}
+}
+
+func TestFetchShardsSubRangeGrows(t *testing.T) {
+	server := &syncServer{paging: false, shardCount: 100, maxShards: 10}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	source := NewHTTPShardSource(ts.Client(), []string{ts.URL}, 0)
+	fetchAll(t, source, 0, 100)
+	state := source.states[0]
+	if state.subRange == 0 {
+		t.Fatal("expect window of 100 shards to be split")
+	}
+	server.lock.Lock()
+	server.maxShards = 100
+	server.lock.Unlock()
+	for i := 0; i < 50 && state.subRange != 0; i++ {
+		fetchAll(t, source, 0, 100)
+	}
+	if state.subRange != 0 {
+		t.Fatalf("expect sub-range to be reset after successful requests, got %d", state.subRange)
+	}
+}
@@ -0,0 +1,366 @@
+package ytcompare
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+//recordOverhead estimated memory overhead of caching one record besides its VHF
+const recordOverhead = 56
+
+//minSpillSize min size of in-memory records a store holds before spilling them, so that spill files are never tiny
+const minSpillSize = 4 * 1024 * 1024
+
+//MemoryBudget memory budget shared by spill stores
+type MemoryBudget struct {
+	limit int64
+	used  int64
+}
+
+//NewMemoryBudget create a new memory budget of limit bytes
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+func (budget *MemoryBudget) acquire(size int64) bool {
+	return atomic.AddInt64(&budget.used, size) > budget.limit
+}
+
+func (budget *MemoryBudget) release(size int64) {
+	atomic.AddInt64(&budget.used, -size)
+}
+
+func (budget *MemoryBudget) exceeded() bool {
+	return atomic.LoadInt64(&budget.used) > budget.limit
+}
+
+//spillSize min size of in-memory records of one store to be spilled when budget is exceeded
+func (budget *MemoryBudget) spillSize() int64 {
+	if budget.limit < minSpillSize {
+		return budget.limit
+	}
+	return minSpillSize
+}
+
+//spillSegment position of shards of one miner in spill file
+type spillSegment struct {
+	offset int64
+	length int64
+}
+
+//spillRun one spill file holding shards of many miners
+type spillRun struct {
+	file     *os.File
+	segments map[int32]spillSegment
+}
+
+//close close and remove spill file
+func (run *spillRun) close() error {
+	err := run.file.Close()
+	if e := os.Remove(run.file.Name()); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+//SpillStore shards store which spills in-memory shards to temporary files when memory budget is exceeded
+type SpillStore struct {
+	lock   sync.RWMutex
+	dir    string
+	budget *MemoryBudget
+	items  map[int32][]*cmpfile.Record
+	size   int64
+	counts map[int32]int64
+	runs   []*spillRun
+	less   RecordLess
+}
+
+var _ ShardStore = (*SpillStore)(nil)
+
+//NewSpillStore create a new SpillStore instance, spill files are created in dir,
+//records of each miner are sorted by less in every spill file, merged and de-duplicated when iterating, keep adding order if less is nil
+func NewSpillStore(dir string, budget *MemoryBudget, less RecordLess) *SpillStore {
+	return &SpillStore{dir: dir, budget: budget, items: make(map[int32][]*cmpfile.Record), counts: make(map[int32]int64), less: less}
+}
+
+//Add add a new record, in-memory records are spilled if memory budget is exceeded and they are not less than spill size of budget
+func (store *SpillStore) Add(nodeID int32, record *cmpfile.Record) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.items[nodeID] = append(store.items[nodeID], record)
+	store.counts[nodeID]++
+	store.size += int64(len(record.VHF)) + recordOverhead
+	if store.budget.acquire(int64(len(record.VHF))+recordOverhead) && store.size >= store.budget.spillSize() {
+		return store.spill()
+	}
+	return nil
+}
+
+//spill write all in-memory records to a new spill file, lock must be held by caller,
+//each record is encoded as shard ID(int64) + block ID(int64) + uvarint length of VHF + VHF
+func (store *SpillStore) spill() error {
+	entry := log.WithFields(log.Fields{Function: "spill"})
+	if store.size == 0 {
+		return nil
+	}
+	file, err := ioutil.TempFile(store.dir, "yotta-compare-spill-")
+	if err != nil {
+		entry.WithError(err).Errorf("creating spill file in %s", store.dir)
+		return err
+	}
+	run := &spillRun{file: file, segments: make(map[int32]spillSegment)}
+	writer := bufio.NewWriter(file)
+	var offset int64
+	buf := make([]byte, 16+binary.MaxVarintLen64)
+	for nodeID, records := range store.items {
+		if store.less != nil {
+			sort.Slice(records, func(i, j int) bool { return store.less(records[i], records[j]) })
+		}
+		start := offset
+		for _, record := range records {
+			binary.BigEndian.PutUint64(buf[0:8], uint64(record.ID))
+			binary.BigEndian.PutUint64(buf[8:16], uint64(record.BlockID))
+			n := 16 + binary.PutUvarint(buf[16:], uint64(len(record.VHF)))
+			if _, err := writer.Write(buf[:n]); err != nil {
+				run.close()
+				return err
+			}
+			if _, err := writer.Write(record.VHF); err != nil {
+				run.close()
+				return err
+			}
+			offset += int64(n + len(record.VHF))
+		}
+		run.segments[nodeID] = spillSegment{offset: start, length: offset - start}
+	}
+	if err := writer.Flush(); err != nil {
+		run.close()
+		entry.WithError(err).Errorf("writing spill file %s", file.Name())
+		return err
+	}
+	entry.Debugf("spilled %d bytes of %d miners to disk", store.size, len(store.items))
+	store.runs = append(store.runs, run)
+	store.budget.release(store.size)
+	store.items = make(map[int32][]*cmpfile.Record)
+	store.size = 0
+	return nil
+}
+
+//Merge move all shards of another store into this store, merged in-memory records are spilled if memory budget is exceeded
+func (store *SpillStore) Merge(other ShardStore) error {
+	o, ok := other.(*SpillStore)
+	if !ok {
+		return fmt.Errorf("cannot merge %T into %T", other, store)
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for nodeID, records := range o.items {
+		store.items[nodeID] = append(store.items[nodeID], records...)
+	}
+	for nodeID, count := range o.counts {
+		store.counts[nodeID] += count
+	}
+	store.size += o.size
+	store.runs = append(store.runs, o.runs...)
+	o.items = make(map[int32][]*cmpfile.Record)
+	o.counts = make(map[int32]int64)
+	o.size = 0
+	o.runs = nil
+	if store.budget.exceeded() && store.size >= store.budget.spillSize() {
+		return store.spill()
+	}
+	return nil
+}
+
+//Miners IDs of all miners having shards in the store
+func (store *SpillStore) Miners() []int32 {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	miners := make([]int32, 0, len(store.counts))
+	for nodeID := range store.counts {
+		miners = append(miners, nodeID)
+	}
+	sort.Slice(miners, func(i, j int) bool { return miners[i] < miners[j] })
+	return miners
+}
+
+//Count count of shards added for one miner, including duplicates
+func (store *SpillStore) Count(nodeID int32) int64 {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.counts[nodeID]
+}
+
+//Clear clear items and remove spill files
+func (store *SpillStore) Clear() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	var err error
+	for _, run := range store.runs {
+		if e := run.close(); e != nil {
+			err = e
+		}
+	}
+	store.budget.release(store.size)
+	store.items = make(map[int32][]*cmpfile.Record)
+	store.counts = make(map[int32]int64)
+	store.size = 0
+	store.runs = nil
+	return err
+}
+
+//Each pass all records of one miner to handler in order of compare file, duplicated records are passed only once
+func (store *SpillStore) Each(nodeID int32, handler func(record *cmpfile.Record) error) error {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	iters := make([]recordIterator, 0, len(store.runs)+1)
+	for _, run := range store.runs {
+		segment, ok := run.segments[nodeID]
+		if !ok {
+			continue
+		}
+		iters = append(iters, &spillIterator{reader: bufio.NewReader(io.NewSectionReader(run.file, segment.offset, segment.length))})
+	}
+	records := append([]*cmpfile.Record(nil), store.items[nodeID]...)
+	if store.less != nil {
+		sort.Slice(records, func(i, j int) bool { return store.less(records[i], records[j]) })
+	}
+	iters = append(iters, &sliceIterator{records: records})
+	if store.less == nil {
+		for _, iter := range iters {
+			for {
+				record, err := iter.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if err := handler(record); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return mergeRecords(iters, store.less, distinct(handler))
+}
+
+//recordIterator iterator of records, io.EOF is returned if no more records
+type recordIterator interface {
+	next() (*cmpfile.Record, error)
+}
+
+//spillIterator iterator of records in one segment of spill file
+type spillIterator struct {
+	reader *bufio.Reader
+}
+
+func (iter *spillIterator) next() (*cmpfile.Record, error) {
+	return readSpillRecord(iter.reader)
+}
+
+//sliceIterator iterator of in-memory records
+type sliceIterator struct {
+	records []*cmpfile.Record
+}
+
+func (iter *sliceIterator) next() (*cmpfile.Record, error) {
+	if len(iter.records) == 0 {
+		return nil, io.EOF
+	}
+	record := iter.records[0]
+	iter.records = iter.records[1:]
+	return record, nil
+}
+
+//mergeItem head record of one iterator
+type mergeItem struct {
+	record *cmpfile.Record
+	iter   recordIterator
+}
+
+//mergeHeap min-heap of head records of sorted iterators
+type mergeHeap struct {
+	items []*mergeItem
+	less  RecordLess
+}
+
+func (h *mergeHeap) Len() int           { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool { return h.less(h.items[i].record, h.items[j].record) }
+func (h *mergeHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(*mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+//mergeRecords merge records of sorted iterators in order of less and pass them to handler
+func mergeRecords(iters []recordIterator, less RecordLess, handler func(record *cmpfile.Record) error) error {
+	h := &mergeHeap{items: make([]*mergeItem, 0, len(iters)), less: less}
+	for _, iter := range iters {
+		record, err := iter.next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h.items = append(h.items, &mergeItem{record: record, iter: iter})
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		item := h.items[0]
+		if err := handler(item.record); err != nil {
+			return err
+		}
+		record, err := item.iter.next()
+		if err == io.EOF {
+			heap.Pop(h)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		item.record = record
+		heap.Fix(h, 0)
+	}
+	return nil
+}
+
+//readSpillRecord read one record from spill file, io.EOF is returned if no more records
+func readSpillRecord(reader *bufio.Reader) (*cmpfile.Record, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	record := &cmpfile.Record{ID: int64(binary.BigEndian.Uint64(buf[0:8])), BlockID: int64(binary.BigEndian.Uint64(buf[8:16])), VHF: make([]byte, length)}
+	if _, err := io.ReadFull(reader, record.VHF); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return record, nil
+}
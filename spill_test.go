@@ -0,0 +1,182 @@
+package ytcompare
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/yottachain/yotta-compare/cmpfile"
+)
+
+func lessByID(a, b *cmpfile.Record) bool {
+	return a.ID < b.ID
+}
+
+//spillRecord record of shard id with 32 bytes VHF
+func spillRecord(id int64) *cmpfile.Record {
+	vhf := bytes.Repeat([]byte{byte(id)}, 32)
+	return &cmpfile.Record{ID: id, BlockID: id / 10, VHF: vhf}
+}
+
+//spillDir create a temporary directory for spill files
+func spillDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "yotta-compare-spill-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+//checkRecords check that records of miner in store are IDs [0, count) in order, each passed once with correct content
+func checkRecords(t *testing.T, store ShardStore, nodeID int32, count int64) {
+	t.Helper()
+	var next int64
+	err := store.Each(nodeID, func(record *cmpfile.Record) error {
+		expected := spillRecord(next)
+		if record.ID != expected.ID || record.BlockID != expected.BlockID || !bytes.Equal(record.VHF, expected.VHF) {
+			t.Fatalf("miner %d: expect record %d but got %d", nodeID, next, record.ID)
+		}
+		next++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != count {
+		t.Fatalf("miner %d: expect %d records but got %d", nodeID, count, next)
+	}
+}
+
+func TestSpillStoreSortedDistinct(t *testing.T) {
+	dir := spillDir(t)
+	defer os.RemoveAll(dir)
+	budget := NewMemoryBudget(1000)
+	store := NewSpillStore(dir, budget, lessByID)
+	defer store.Clear()
+	//add every record of miner 1 twice and every third record of miner 2 twice, duplicates are likely in different spill files
+	var added1, added2 int64
+	for pass := 0; pass < 2; pass++ {
+		for _, id := range rand.Perm(100) {
+			if err := store.Add(1, spillRecord(int64(id))); err != nil {
+				t.Fatal(err)
+			}
+			added1++
+			if id < 50 && (pass == 0 || id%3 == 0) {
+				if err := store.Add(2, spillRecord(int64(id))); err != nil {
+					t.Fatal(err)
+				}
+				added2++
+			}
+		}
+	}
+	if len(store.runs) < 2 || len(store.items) == 0 {
+		t.Fatalf("expect records in several spill files and memory, got %d spill files, %d miners in memory", len(store.runs), len(store.items))
+	}
+	if store.Count(1) != added1 || store.Count(2) != added2 {
+		t.Fatalf("expect counts %d and %d but got %d and %d", added1, added2, store.Count(1), store.Count(2))
+	}
+	checkRecords(t, store, 1, 100)
+	checkRecords(t, store, 2, 50)
+}
+
+func TestSpillStoreMerge(t *testing.T) {
+	dir := spillDir(t)
+	defer os.RemoveAll(dir)
+	budget := NewMemoryBudget(1000)
+	total := NewSpillStore(dir, budget, lessByID)
+	defer total.Clear()
+	//first store spills while adding, the others are smaller than spill size and spilled after merging
+	sizes := []int64{40, 8, 8, 8}
+	var id, spilled int64
+	for i, size := range sizes {
+		store := NewSpillStore(dir, budget, lessByID)
+		for j := int64(0); j < size; j++ {
+			if err := store.Add(int32(j%2), spillRecord(id)); err != nil {
+				t.Fatal(err)
+			}
+			id++
+		}
+		if i == 0 && len(store.runs) == 0 {
+			t.Fatal("expect records to be spilled while adding")
+		}
+		if i > 0 && len(store.runs) > 0 {
+			t.Fatal("expect records less than spill size not to be spilled")
+		}
+		spilled += int64(len(store.runs))
+		if err := total.Merge(store); err != nil {
+			t.Fatal(err)
+		}
+		if store.Count(0) != 0 || len(store.Miners()) != 0 || len(store.runs) != 0 {
+			t.Fatal("expect merged store to be empty")
+		}
+	}
+	if int64(len(total.runs)) <= spilled {
+		t.Fatalf("expect merged records to be spilled, got %d spill files after merging %d", len(total.runs), spilled)
+	}
+	if total.Count(0)+total.Count(1) != id {
+		t.Fatalf("expect %d records but got %d", id, total.Count(0)+total.Count(1))
+	}
+	var count int64
+	for _, nodeID := range total.Miners() {
+		last := int64(-1)
+		err := total.Each(nodeID, func(record *cmpfile.Record) error {
+			if record.ID <= last || record.ID%2 != int64(nodeID) {
+				t.Fatalf("miner %d: unexpected record %d after %d", nodeID, record.ID, last)
+			}
+			last = record.ID
+			count++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != id {
+		t.Fatalf("expect %d records but got %d", id, count)
+	}
+}
+
+func TestSpillStoreClear(t *testing.T) {
+	dir := spillDir(t)
+	defer os.RemoveAll(dir)
+	budget := NewMemoryBudget(1000)
+	store := NewSpillStore(dir, budget, lessByID)
+	other := NewSpillStore(dir, budget, lessByID)
+	for id := int64(0); id < 100; id++ {
+		if err := store.Add(int32(id%3), spillRecord(id)); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Add(int32(id%3), spillRecord(id+100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 || budget.used == 0 {
+		t.Fatalf("expect spill files and memory in use, got %d files, %d bytes", len(files), budget.used)
+	}
+	if err := store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	files, err = ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expect spill files to be removed, got %d files", len(files))
+	}
+	if budget.used != 0 {
+		t.Fatalf("expect all memory to be released, got %d bytes in use", budget.used)
+	}
+	if len(store.Miners()) != 0 {
+		t.Fatal("expect store to be empty")
+	}
+}
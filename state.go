@@ -0,0 +1,51 @@
+package ytcompare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	//StateStoreMongo state is saved in MongoDB
+	StateStoreMongo = "mongo"
+	//StateStoreBolt state is saved in embedded bbolt database file
+	StateStoreBolt = "bolt"
+)
+
+//ErrStateNotFound state record not found
+var ErrStateNotFound = errors.New("state not found")
+
+//StateStore store of service state, including checkpoint of windows, cursors of miners, conflicts of shards and public keys of miners
+type StateStore interface {
+	//GetCheckPoint get checkpoint of the last committed window, ErrStateNotFound is returned if no window is committed
+	GetCheckPoint(ctx context.Context) (*CheckPoint, error)
+	//GetCursor get cursor of miner, ErrStateNotFound is returned if no compare file is committed for the miner
+	GetCursor(ctx context.Context, nodeID int32) (*Cursor, error)
+	//Commit write cursors and checkpoint of journal atomically
+	Commit(ctx context.Context, journal *Journal) error
+	//Recover finish the last commit if it is interrupted
+	Recover(ctx context.Context) error
+	//SaveConflicts save conflicts of one window, assignments of the same shard in the same window are merged
+	SaveConflicts(ctx context.Context, conflicts []*Conflict) error
+	//GetMinerKey get base64 encoded public key of miner, ErrStateNotFound is returned if the miner has no public key
+	GetMinerKey(ctx context.Context, nodeID int32) (string, error)
+	//PutMinerKey set base64 encoded public key of miner
+	PutMinerKey(ctx context.Context, nodeID int32, publicKey string) error
+	//Close close the store
+	Close(ctx context.Context) error
+}
+
+//NewStateStore create state store by configuration
+func NewStateStore(ctx context.Context, config *Config) (StateStore, error) {
+	keyCollection := config.Encryption.KeyCollection
+	switch strings.ToLower(config.StateStore) {
+	case StateStoreMongo, "":
+		return NewMongoStateStore(ctx, config.MongoDBURL, config.DBName, keyCollection)
+	case StateStoreBolt:
+		return NewBoltStateStore(config.Bolt.Path, keyCollection)
+	default:
+		return nil, fmt.Errorf("no such state store: %s", config.StateStore)
+	}
+}
@@ -2,47 +2,122 @@ package ytcompare
 
 import (
 	"bytes"
-	"compress/gzip"
+	"fmt"
+	"sort"
 	"sync"
+
+	"github.com/yottachain/yotta-compare/cmpfile"
 )
 
+//ShardStore store of compare records grouped by miner in one window
+type ShardStore interface {
+	//Add add a new record
+	Add(nodeID int32, record *cmpfile.Record) error
+	//Merge move all shards of another store into this store, the other store is empty after merging
+	Merge(other ShardStore) error
+	//Miners IDs of all miners having shards in the store
+	Miners() []int32
+	//Count count of shards added for one miner, including duplicates
+	Count(nodeID int32) int64
+	//Each pass all records of one miner to handler in order of compare file, duplicated records are passed only once
+	Each(nodeID int32, handler func(record *cmpfile.Record) error) error
+	//Clear clear items and release resources
+	Clear() error
+}
+
+//RecordLess reports whether record a must precede record b in compare file
+type RecordLess func(a, b *cmpfile.Record) bool
+
+//distinct wrap handler for skipping adjacent records with same shard ID and VHF
+func distinct(handler func(record *cmpfile.Record) error) func(record *cmpfile.Record) error {
+	var last *cmpfile.Record
+	return func(record *cmpfile.Record) error {
+		if last != nil && last.ID == record.ID && bytes.Equal(last.VHF, record.VHF) {
+			return nil
+		}
+		last = record
+		return handler(record)
+	}
+}
+
 //Store instance
 type Store struct {
-	Items map[int32][][]byte
+	Items map[int32][]*cmpfile.Record
 	locks []sync.RWMutex
+	less  RecordLess
 }
 
-//NewStore create a new shards store
-func NewStore() *Store {
+var _ ShardStore = (*Store)(nil)
+
+//NewStore create a new shards store, records of each miner are sorted by less and de-duplicated when iterating, keep adding order if less is nil
+func NewStore(less RecordLess) *Store {
 	locks := make([]sync.RWMutex, 0)
 	for i := uint64(0); i < 1000; i++ {
 		locks = append(locks, sync.RWMutex{})
 	}
-	return &Store{Items: make(map[int32][][]byte, 0), locks: locks}
+	return &Store{Items: make(map[int32][]*cmpfile.Record, 0), locks: locks, less: less}
 }
 
-//Add add a new shard
-func (store *Store) Add(nodeID int32, shard []byte) {
+//Add add a new record
+func (store *Store) Add(nodeID int32, record *cmpfile.Record) error {
 	store.locks[int(nodeID)%len(store.locks)].Lock()
 	defer store.locks[int(nodeID)%len(store.locks)].Unlock()
-	store.Items[nodeID] = append(store.Items[nodeID], shard)
+	store.Items[nodeID] = append(store.Items[nodeID], record)
+	return nil
+}
+
+//Merge move all shards of another store into this store
+func (store *Store) Merge(other ShardStore) error {
+	o, ok := other.(*Store)
+	if !ok {
+		return fmt.Errorf("cannot merge %T into %T", other, store)
+	}
+	for nodeID, shards := range o.Items {
+		store.locks[int(nodeID)%len(store.locks)].Lock()
+		store.Items[nodeID] = append(store.Items[nodeID], shards...)
+		store.locks[int(nodeID)%len(store.locks)].Unlock()
+	}
+	o.Items = make(map[int32][]*cmpfile.Record)
+	return nil
+}
+
+//Miners IDs of all miners having shards in the store
+func (store *Store) Miners() []int32 {
+	miners := make([]int32, 0, len(store.Items))
+	for nodeID := range store.Items {
+		miners = append(miners, nodeID)
+	}
+	sort.Slice(miners, func(i, j int) bool { return miners[i] < miners[j] })
+	return miners
+}
+
+//Count count of shards added for one miner, including duplicates
+func (store *Store) Count(nodeID int32) int64 {
+	store.locks[int(nodeID)%len(store.locks)].RLock()
+	defer store.locks[int(nodeID)%len(store.locks)].RUnlock()
+	return int64(len(store.Items[nodeID]))
 }
 
 //Clear clear items
-func (store *Store) Clear() {
-	store.Items = make(map[int32][][]byte)
+func (store *Store) Clear() error {
+	store.Items = make(map[int32][]*cmpfile.Record)
+	return nil
 }
 
-//GenerateData compressed compare data for one miner
-func (store *Store) GenerateData(nodeID int32) (bytes.Buffer, error) {
-	var res bytes.Buffer
-	gz, _ := gzip.NewWriterLevel(&res, 7)
-	for _, b := range store.Items[nodeID] {
-		_, err := gz.Write(b)
+//Each pass all records of one miner to handler in order of compare file, duplicated records are passed only once
+func (store *Store) Each(nodeID int32, handler func(record *cmpfile.Record) error) error {
+	store.locks[int(nodeID)%len(store.locks)].Lock()
+	defer store.locks[int(nodeID)%len(store.locks)].Unlock()
+	records := store.Items[nodeID]
+	if store.less != nil {
+		sort.Slice(records, func(i, j int) bool { return store.less(records[i], records[j]) })
+		handler = distinct(handler)
+	}
+	for _, record := range records {
+		err := handler(record)
 		if err != nil {
-			return res, err
+			return err
 		}
 	}
-	gz.Close()
-	return res, nil
+	return nil
 }
@@ -16,6 +16,26 @@ const (
 	CheckPointTab = "checkpoint"
 	//CursorTab cursor table
 	CursorTab = "cursor"
+	//ConflictTab conflict table
+	ConflictTab = "conflict"
+	//JournalTab journal table
+	JournalTab = "journal"
+)
+
+const (
+	//RecordModeVHF records of compare file contain VHF only
+	RecordModeVHF = "vhf"
+	//RecordModeFull records of compare file contain shard ID, block ID and VHF
+	RecordModeFull = "full"
+)
+
+const (
+	//ChainModeTag compare files are chained by tags of previous file
+	ChainModeTag = "tag"
+	//ChainModeManifest compare files are chained by manifest of miner
+	ChainModeManifest = "manifest"
+	//ChainModeBoth compare files are chained by both tags and manifest
+	ChainModeBoth = "both"
 )
 
 //Shard struct
@@ -28,17 +48,40 @@ type Shard struct {
 
 //CheckPoint struct
 type CheckPoint struct {
-	ID        int32 `bson:"_id"`
-	Start     int64 `bson:"start"`
-	Range     int64 `bson:"range"`
-	Timestamp int64 `bson:"timestamp"`
+	ID        int32 `bson:"_id" json:"_id"`
+	Start     int64 `bson:"start" json:"start"`
+	Range     int64 `bson:"range" json:"range"`
+	Shards    int64 `bson:"shards" json:"shards"`
+	Timestamp int64 `bson:"timestamp" json:"timestamp"`
 }
 
 //Cursor struct
 type Cursor struct {
-	ID        int32 `bson:"_id"`
-	From      int64 `bson:"from"`
-	Range     int64 `bson:"range"`
-	FileFrom  int64 `bson:"fileFrom"`
-	Timestamp int64 `bson:"timestamp"`
+	ID        int32 `bson:"_id" json:"_id"`
+	From      int64 `bson:"from" json:"from"`
+	Range     int64 `bson:"range" json:"range"`
+	FileFrom  int64 `bson:"fileFrom" json:"fileFrom"`
+	Timestamp int64 `bson:"timestamp" json:"timestamp"`
+}
+
+//Conflict struct
+type Conflict struct {
+	ShardID     int64         `bson:"shardId" json:"shardId"`
+	Start       int64         `bson:"start" json:"start"`
+	Range       int64         `bson:"range" json:"range"`
+	Assignments []*Assignment `bson:"assignments" json:"assignments"`
+	Timestamp   int64         `bson:"timestamp" json:"timestamp"`
+}
+
+//Assignment struct
+type Assignment struct {
+	SNID    int32  `bson:"snId" json:"snId"`
+	NodeID  int32  `bson:"nodeId" json:"nodeId"`
+	VHFHash string `bson:"vhfHash" json:"vhfHash"`
+}
+
+//MinerKey struct
+type MinerKey struct {
+	ID        int32  `bson:"_id" json:"_id"`
+	PublicKey string `bson:"publicKey" json:"publicKey"`
 }
@@ -0,0 +1,78 @@
+package ytcompare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//IndexLatestKey key of object holding a copy of the latest window index
+const IndexLatestKey = "index/latest"
+
+//WindowIndex index of all compare files uploaded in one window, saved as object index/<start>
+type WindowIndex struct {
+	Version   int           `json:"version"`
+	Start     int64         `json:"start"`
+	Range     int64         `json:"range"`
+	Timestamp int64         `json:"timestamp"`
+	Miners    []*IndexEntry `json:"miners"`
+	//Skipped miners having shards in the window but no compare file uploaded since they have no public key for encryption
+	Skipped []int32 `json:"skipped,omitempty"`
+}
+
+//IndexEntry compare file of one miner in window index
+type IndexEntry struct {
+	MinerID  int32  `json:"minerId"`
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"sha256"`
+}
+
+//windowIndexKey key of window index object
+func windowIndexKey(start int64) string {
+	return fmt.Sprintf("index/%d", start)
+}
+
+//newWindowIndex create window index of files uploaded for miners and miners skipped, sorted by miner ID
+func newWindowIndex(start, timeRange int64, files map[int32]*ManifestFile, skipped []int32) *WindowIndex {
+	index := &WindowIndex{Version: ManifestVersion, Start: start, Range: timeRange, Timestamp: time.Now().Unix(), Miners: make([]*IndexEntry, 0, len(files)), Skipped: skipped}
+	for nodeID, file := range files {
+		index.Miners = append(index.Miners, &IndexEntry{MinerID: nodeID, Key: file.Key, Size: file.Size, Checksum: file.Checksum})
+	}
+	sort.Slice(index.Miners, func(i, j int) bool { return index.Miners[i].MinerID < index.Miners[j].MinerID })
+	return index
+}
+
+//putWindowIndex upload window index as index/<start> and index/latest, retrying with exponential backoff on failure
+func (compare *Compare) putWindowIndex(ctx context.Context, index *WindowIndex) error {
+	entry := log.WithFields(log.Fields{Function: "putWindowIndex"})
+	data, err := json.Marshal(index)
+	if err != nil {
+		entry.WithError(err).Error("encoding window index")
+		return err
+	}
+	for _, key := range []string{windowIndexKey(index.Start), IndexLatestKey} {
+		for attempt := 0; ; attempt++ {
+			err := compare.Storage.Put(ctx, key, bytes.NewReader(data))
+			if err == nil {
+				break
+			}
+			if attempt >= compare.RetryTimes {
+				entry.WithError(err).Errorf("uploading window index %s failed after %d retries", key, attempt)
+				return err
+			}
+			wait := compare.backoff.Duration(attempt)
+			entry.WithError(err).Warnf("uploading window index %s failed, retry after %s", key, wait)
+			if !sleep(ctx, wait) {
+				return ctx.Err()
+			}
+		}
+	}
+	entry.Debugf("window index of %d miners uploaded from %d to %d", len(index.Miners), index.Start, index.Start+index.Range)
+	return nil
+}